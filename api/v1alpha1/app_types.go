@@ -1,22 +1,278 @@
 package v1alpha1
 
-import "go.uber.org/zap"
+import (
+	"encoding/json"
+	"sync/atomic"
+	"time"
 
-// Context TODO
+	"go.uber.org/zap"
+)
+
+// Context is shared by all the components of the application.
+// The configuration can be swapped at any time when it is reloaded, so it is read through Config
 type Context struct {
-	Config *ConfigSpec
+	config *atomic.Pointer[ConfigSpec]
 	Logger *zap.SugaredLogger
 }
 
+// NewContext returns a context holding the given configuration
+func NewContext(config *ConfigSpec, logger *zap.SugaredLogger) Context {
+	ctx := Context{config: &atomic.Pointer[ConfigSpec]{}, Logger: logger}
+	ctx.config.Store(config)
+	return ctx
+}
+
+// Config returns the current configuration. It must not be modified
+func (c Context) Config() *ConfigSpec {
+	return c.config.Load()
+}
+
+// SetConfig atomically replaces the configuration seen by every copy of the context
+func (c Context) SetConfig(config *ConfigSpec) {
+	c.config.Store(config)
+}
+
+const (
+	AuthMethodJWT    = "jwt"
+	AuthMethodAPIKey = "apikey"
+	AuthMethodOIDC   = "oidc"
+)
+
+// Identity is the authenticated caller of the API
+type Identity struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+	Method string   `json:"method"`
+}
+
 type PurgeRequest struct {
-	PurgeType        string   `json:"purgeType"`                  // "urls" or "cache-tags"
+	PurgeType        string   `json:"purgeType"`                  // "urls", "cache-tags" or "cpcodes"
 	ActionType       string   `json:"actionType"`                 // "invalidate" or "delete"
 	Environment      string   `json:"environment"`                // "production" or "staging"
 	PostPurgeRequest bool     `json:"postPurgeRequest,omitempty"` // true or false
+	DryRun           bool     `json:"dryRun,omitempty"`           // Plan the purge without calling Akamai
+	Account          string   `json:"account,omitempty"`          // Akamai account, routed by hostname when empty
 	Paths            []string `json:"paths"`
 }
 
+// AkamaiResponse is the response of the Fast Purge API.
+// Error is only set when Akamai answered with a problem+json body
+// Ref: https://techdocs.akamai.com/purge-cache/reference/api-errors
 type AkamaiResponse struct {
-	HTTPStatus int    `json:"httpStatus"`
-	Detail     string `json:"detail"`
+	HTTPStatus       int            `json:"httpStatus"`
+	Detail           string         `json:"detail"`
+	PurgeID          string         `json:"purgeId,omitempty"`
+	EstimatedSeconds int            `json:"estimatedSeconds,omitempty"`
+	SupportID        string         `json:"supportId,omitempty"`
+	Title            string         `json:"title,omitempty"`
+	DescribedBy      string         `json:"describedBy,omitempty"`
+	Error            *AkamaiProblem `json:"error,omitempty"`
+}
+
+// AkamaiProblem is the problem+json (RFC 7807) body returned by Akamai on errors
+type AkamaiProblem struct {
+	Type        string `json:"type,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Status      int    `json:"status,omitempty"`
+	Detail      string `json:"detail,omitempty"`
+	Instance    string `json:"instance,omitempty"`
+	Method      string `json:"method,omitempty"`
+	RequestID   string `json:"requestId,omitempty"`
+	RequestTime string `json:"requestTime,omitempty"`
+	ServerIP    string `json:"serverIp,omitempty"`
+	ClientIP    string `json:"clientIp,omitempty"`
+}
+
+// PurgeResponse is the combined response for a purge request.
+// Paths are sent to Akamai in several batches when they do not fit in a single request
+type PurgeResponse struct {
+	Account          string             `json:"account"`
+	HTTPStatus       int                `json:"httpStatus"`
+	Detail           string             `json:"detail"`
+	EstimatedSeconds int                `json:"estimatedSeconds"`
+	Batches          []PurgeBatch       `json:"batches"`
+	Propagation      *PropagationReport `json:"propagation,omitempty"`
+	Warming          *WarmingSummary    `json:"warming,omitempty"`
+}
+
+// WarmingSummary is the outcome of the post-purge requests.
+// URLs not requested before the deadline are skipped
+type WarmingSummary struct {
+	Requested int            `json:"requested"`
+	Statuses  map[int]int    `json:"statuses"` // Number of responses by status code
+	Failed    int            `json:"failed"`
+	Skipped   int            `json:"skipped"`
+	Slowest   []WarmedURL    `json:"slowest"`
+	Errors    []WarmedURL    `json:"errors"`   // First errors only, Failed counts them all
+	Verdicts  map[string]int `json:"verdicts"` // Number of responses by verdict
+	URLs      []WarmedURL    `json:"urls"`
+	Duration  string         `json:"duration"`
+}
+
+// WarmedURL is the result of a post-purge request, with the cache status, cache key and age
+// returned by the Akamai edge, and the verdict drawn from them
+type WarmedURL struct {
+	URL      string `json:"url"`
+	Status   int    `json:"status,omitempty"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+	Cache    string `json:"cache,omitempty"`
+	CacheKey string `json:"cacheKey,omitempty"`
+	Age      *int   `json:"age,omitempty"`
+	Verdict  string `json:"verdict,omitempty"`
+}
+
+// Verdicts of the post-purge requests, telling whether the edge served an object cached after the purge
+const (
+	VerdictFetched      = "miss-then-fetched" // Not in cache, fetched from the origin
+	VerdictRevalidated  = "revalidated"       // Invalidated in cache, revalidated with the origin
+	VerdictFreshHit     = "fresh-hit"         // Cached since the purge
+	VerdictStaleHit     = "still-stale-hit"   // Cached before the purge
+	VerdictNotCacheable = "not-cacheable"
+	VerdictUnknown      = "unknown" // No debug headers, or a hit without Age
+)
+
+// PropagationReport is the outcome of the polling of the purged URLs until the edge serves new objects.
+// URLs without ETag, Last-Modified nor Akamai debug headers before the purge can not be verified
+type PropagationReport struct {
+	Changed    int      `json:"changed"`
+	Unchanged  []string `json:"unchanged"` // Still serving the old objects at the timeout
+	Unverified []string `json:"unverified"`
+	Duration   string   `json:"duration"`
+}
+
+// PurgePlan describes what a purge would do, returned instead of running it in dry-run mode
+type PurgePlan struct {
+	DryRun           bool           `json:"dryRun"`
+	Account          string         `json:"account"`
+	ApprovalRequired string         `json:"approvalRequired,omitempty"` // Approval rule the purge would wait for
+	Calls            []PlannedCall  `json:"calls"`
+	PostPurge        *PostPurgePlan `json:"postPurge,omitempty"`
+}
+
+// PlannedCall is a call to the Fast Purge API, with the exact payload that would be sent
+type PlannedCall struct {
+	Method  string          `json:"method"`
+	URL     string          `json:"url"`
+	Objects int             `json:"objects"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// PostPurgePlan describes the warm-up requests sent once the purge is accepted
+type PostPurgePlan struct {
+	Delay           string           `json:"delay"`
+	PollPropagation bool             `json:"pollPropagation,omitempty"`
+	Requests        []PlannedRequest `json:"requests"`
+}
+
+// PlannedRequest is a warm-up request sent after the purge
+type PlannedRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// PurgeBatch is the result of a single call to the Fast Purge API
+type PurgeBatch struct {
+	Objects  int `json:"objects"`
+	Attempts int `json:"attempts"`
+	AkamaiResponse
+}
+
+// ValidationError describes a wrong field of a request.
+// Index is only set for fields that are lists
+type ValidationError struct {
+	Field   string `json:"field"`
+	Index   *int   `json:"index,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message"`
+}
+
+const (
+	JobStageQueued    = "queued"
+	JobStageSubmitted = "submitted"
+	JobStageWaiting   = "waiting"
+	JobStagePolling   = "polling"
+	JobStageWarming   = "warming"
+	JobStageDone      = "done"
+	JobStageFailed    = "failed"
+)
+
+// PurgeJob is a purge running in background.
+// Progress counts the batches sent to Akamai while submitted, and the warmed paths while warming
+type PurgeJob struct {
+	ID        string         `json:"id"`
+	Stage     string         `json:"stage"`
+	Progress  JobProgress    `json:"progress"`
+	Request   PurgeRequest   `json:"request"`
+	Response  *PurgeResponse `json:"response,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+type JobProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
+const (
+	PurgeStatusSucceeded = "succeeded"
+	PurgeStatusPartial   = "partial"
+	PurgeStatusFailed    = "failed"
+
+	// Statuses of the purges waiting for, or refused, a second user approval
+	PurgeStatusPending  = "pending"
+	PurgeStatusApproved = "approved"
+	PurgeStatusRejected = "rejected"
+	PurgeStatusExpired  = "expired"
+)
+
+// PurgeRecord is a purge stored in the history
+type PurgeRecord struct {
+	ID          string         `json:"id"`
+	User        string         `json:"user"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	PurgeType   string         `json:"purgeType"`
+	ActionType  string         `json:"actionType"`
+	Environment string         `json:"environment"`
+	Account     string         `json:"account,omitempty"`
+	Paths       []string       `json:"paths"`
+	PurgeIDs    []string       `json:"purgeIds"`
+	Status      string         `json:"status"`
+	Detail      string         `json:"detail"`
+	Result      *PurgeResponse `json:"result,omitempty"`
+
+	// PostPurgeRequest and Approval are kept for the purges needing approval, to run them once approved
+	PostPurgeRequest bool      `json:"postPurgeRequest,omitempty"`
+	Approval         *Approval `json:"approval,omitempty"`
+}
+
+// Approval tracks the review of a purge by a second user
+type Approval struct {
+	Rule       string     `json:"rule"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	Reviewer   string     `json:"reviewer,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	ReviewedAt *time.Time `json:"reviewedAt,omitempty"`
+}
+
+// PurgeFilter selects records from the history. Empty fields match every record
+type PurgeFilter struct {
+	User   string
+	From   time.Time
+	To     time.Time
+	Path   string
+	Status string
+	Offset int
+	Limit  int
+}
+
+// PurgeList is a page of records from the history
+type PurgeList struct {
+	Total  int           `json:"total"`
+	Offset int           `json:"offset"`
+	Limit  int           `json:"limit"`
+	Items  []PurgeRecord `json:"items"`
 }
@@ -1,5 +1,7 @@
 package v1alpha1
 
+import "time"
+
 // Configuration struct
 type ConfigSpec struct {
 	Server struct {
@@ -13,11 +15,79 @@ type ConfigSpec struct {
 		ClientSecret string `yaml:"client_secret"`
 		ClientToken  string `yaml:"client_token"`
 		AccessToken  string `yaml:"access_token"`
+		// Edgerc and Section load the credentials from an edgerc file instead
+		Edgerc  string `yaml:"edgerc"`
+		Section string `yaml:"section"`
+		// AccountSwitchKey lets the credentials act on another account they manage
+		AccountSwitchKey string `yaml:"account_switch_key"`
+		// Accounts are additional named credential sets, selected by name or by the hostnames of the URLs
+		Accounts []AkamaiAccount `yaml:"accounts"`
+		// RequestTimeout limits every attempt of a call to Akamai, so that hanging calls are retried
+		RequestTimeout time.Duration `yaml:"request_timeout"`
+		Retry          struct {
+			MaxAttempts    int           `yaml:"max_attempts"`
+			InitialBackoff time.Duration `yaml:"initial_backoff"`
+			MaxBackoff     time.Duration `yaml:"max_backoff"`
+		} `yaml:"retry"`
 	} `yaml:"akamai"`
+	Secrets   SecretsConfig `yaml:"secrets"`
+	RateLimit struct {
+		Enabled      bool            `yaml:"enabled"`
+		Mode         string          `yaml:"mode"` // "queue" or "reject"
+		QueueTimeout time.Duration   `yaml:"queue_timeout"`
+		URLs         RateLimitBucket `yaml:"urls"`
+		CacheTags    RateLimitBucket `yaml:"cache_tags"`
+		CPCodes      RateLimitBucket `yaml:"cpcodes"`
+	} `yaml:"rate_limit"`
+	Jobs struct {
+		// Retention is the time finished asynchronous purge jobs are kept
+		Retention time.Duration `yaml:"retention"`
+	} `yaml:"jobs"`
+	History struct {
+		Enabled bool   `yaml:"enabled"`
+		Path    string `yaml:"path"`
+	} `yaml:"history"`
+	Policies struct {
+		Enabled bool         `yaml:"enabled"`
+		Rules   []PolicyRule `yaml:"rules"`
+	} `yaml:"policies"`
+	Approvals struct {
+		Enabled bool           `yaml:"enabled"`
+		Rules   []ApprovalRule `yaml:"rules"`
+		// Approvers restricts who may review the purges. When empty, any authenticated user can
+		Approvers struct {
+			Users  []string `yaml:"users"`
+			Groups []string `yaml:"groups"`
+		} `yaml:"approvers"`
+		Expiration time.Duration `yaml:"expiration"`
+	} `yaml:"approvals"`
+	CPCodes struct {
+		// Teams restricts the CP codes each team is allowed to purge.
+		// When no team is defined, every CP code can be purged
+		Teams []CPCodesTeam `yaml:"teams"`
+	} `yaml:"cpcodes"`
 	PostPurgeRequest struct {
-		Enabled bool              `yaml:"enabled"`
-		Headers map[string]string `yaml:"headers"`
+		Enabled bool `yaml:"enabled"`
+		// Delay is the time waited for the purge to propagate before the requests, unset when not configured
+		// so that 0 disables the wait. With WaitForEstimate, the estimatedSeconds returned by Akamai are
+		// waited instead, up to MaxDelay
+		Delay           *time.Duration    `yaml:"delay"`
+		WaitForEstimate bool              `yaml:"wait_for_estimate"`
+		MaxDelay        time.Duration     `yaml:"max_delay"`
+		Propagation     PropagationConfig `yaml:"propagation"`
+		// Requests are sent by Concurrency workers, each one limited to RequestTimeout, and all of them to Deadline
+		Concurrency    int               `yaml:"concurrency"`
+		RequestTimeout time.Duration     `yaml:"request_timeout"`
+		Deadline       time.Duration     `yaml:"deadline"`
+		Headers        map[string]string `yaml:"headers"`
 	} `yaml:"post_purge_request"`
+	Auth struct {
+		// Required rejects the calls to the purge API without valid credentials
+		Required bool          `yaml:"required"`
+		JWT      JWTConfig     `yaml:"jwt"`
+		APIKeys  APIKeysConfig `yaml:"api_keys"`
+		OIDC     OIDCConfig    `yaml:"oidc"`
+	} `yaml:"auth"`
 	Logs struct {
 		ShowAccessLogs bool `yaml:"show_access_logs"`
 		JwtUser        struct {
@@ -28,3 +98,123 @@ type ConfigSpec struct {
 		AccessLogsFields []string `yaml:"access_logs_fields"`
 	} `yaml:"logs"`
 }
+
+// CPCodesTeam defines the CP codes a group of users is allowed to purge
+type CPCodesTeam struct {
+	Name    string   `yaml:"name"`
+	Users   []string `yaml:"users"`
+	CPCodes []int    `yaml:"cpcodes"`
+}
+
+// RateLimitBucket defines the token bucket for a purge type. Tokens are purged objects
+type RateLimitBucket struct {
+	ObjectsPerSecond float64 `yaml:"objects_per_second"`
+	Burst            int     `yaml:"burst"`
+}
+
+// JWTConfig defines how the JWTs sent to the API are verified.
+// Tokens are read from the cookie when it is set, and from the header when the cookie is not sent
+type JWTConfig struct {
+	Enabled             bool          `yaml:"enabled"`
+	Header              string        `yaml:"header"`
+	Cookie              string        `yaml:"cookie"`
+	PublicKeyFile       string        `yaml:"public_key_file"`
+	JWKSFile            string        `yaml:"jwks_file"`
+	JWKSURL             string        `yaml:"jwks_url"`
+	JWKSRefreshInterval time.Duration `yaml:"jwks_refresh_interval"`
+	Issuer              string        `yaml:"issuer"`
+	Audience            string        `yaml:"audience"`
+	UserClaim           string        `yaml:"user_claim"`
+	GroupsClaim         string        `yaml:"groups_claim"`
+	Leeway              time.Duration `yaml:"leeway"`
+}
+
+// PolicyRule defines what the users and groups it applies to may purge.
+// Empty lists allow any value. Hostnames and tag patterns accept globs (e.g. *.example.com).
+// Allow rules limiting the URLs, the tags or the CP codes only allow the purge types they limit
+type PolicyRule struct {
+	Name         string   `yaml:"name"`
+	Effect       string   `yaml:"effect"` // "allow" (default) or "deny"
+	Users        []string `yaml:"users"`
+	Groups       []string `yaml:"groups"`
+	Hostnames    []string `yaml:"hostnames"`
+	URLPrefixes  []string `yaml:"url_prefixes"`
+	TagPatterns  []string `yaml:"tag_patterns"`
+	CPCodes      []int    `yaml:"cpcodes"`
+	ActionTypes  []string `yaml:"action_types"`
+	Environments []string `yaml:"environments"`
+}
+
+// AkamaiAccount is a named set of Akamai credentials, set inline or loaded from an edgerc file.
+// Accounts without their own credentials use the default ones, which is useful along with an account switch key
+type AkamaiAccount struct {
+	Name             string   `yaml:"name"`
+	Host             string   `yaml:"host"`
+	ClientSecret     string   `yaml:"client_secret"`
+	ClientToken      string   `yaml:"client_token"`
+	AccessToken      string   `yaml:"access_token"`
+	Edgerc           string   `yaml:"edgerc"`
+	Section          string   `yaml:"section"`
+	AccountSwitchKey string   `yaml:"account_switch_key"`
+	Hostnames        []string `yaml:"hostnames"` // Globs of the hostnames routed to the account (e.g. *.example.com)
+}
+
+// ApprovalRule defines the purges that must be approved by a second user before running.
+// A request matches when it matches all the conditions set in the rule
+type ApprovalRule struct {
+	Name         string   `yaml:"name"`
+	ActionTypes  []string `yaml:"action_types"`
+	Environments []string `yaml:"environments"`
+	MaxPaths     int      `yaml:"max_paths"` // Requests with more paths than this need approval
+}
+
+// APIKeysConfig defines the static API keys, from the configuration and from an optional keys file
+type APIKeysConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	File    string   `yaml:"file"`
+	Keys    []APIKey `yaml:"keys"`
+}
+
+// APIKey is a static API key. Only the SHA-256 hash of the key is stored (sha256:<hex>)
+type APIKey struct {
+	Name    string    `yaml:"name"`
+	Hash    string    `yaml:"hash"`
+	Expires time.Time `yaml:"expires"`
+	Scopes  []string  `yaml:"scopes"`
+	Groups  []string  `yaml:"groups"`
+}
+
+// OIDCConfig defines the OpenID Connect login of the web UI, using the authorization code flow.
+// Logged-in users are kept in a session cookie signed with the session secret
+type OIDCConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	Issuer          string        `yaml:"issuer"`
+	ClientID        string        `yaml:"client_id"`
+	ClientSecret    string        `yaml:"client_secret"`
+	RedirectURL     string        `yaml:"redirect_url"`
+	Scopes          []string      `yaml:"scopes"`
+	UserClaim       string        `yaml:"user_claim"`
+	GroupsClaim     string        `yaml:"groups_claim"`
+	SessionSecret   string        `yaml:"session_secret"`
+	SessionDuration time.Duration `yaml:"session_duration"`
+	CookieName      string        `yaml:"cookie_name"`
+}
+
+// SecretsConfig defines how the secret references of the configuration (file://, exec:, http(s)://) are resolved
+type SecretsConfig struct {
+	// RefreshInterval is how often the references are resolved again, to pick up rotated secrets
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	Timeout         time.Duration `yaml:"timeout"`
+	HTTP            struct {
+		Headers map[string]string `yaml:"headers"`
+	} `yaml:"http"`
+}
+
+// PropagationConfig defines the polling of the purged URLs after the delay, until the edge stops serving
+// the objects it served before the purge, detected by a change of their ETag or Last-Modified headers,
+// or by the Akamai debug headers of the objects fetched or cached since the purge
+type PropagationConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
@@ -4,13 +4,14 @@ go 1.23.4
 
 require (
 	github.com/akamai/AkamaiOPEN-edgegrid-golang/v9 v9.1.0
-	github.com/go-ini/ini v1.67.0
 	github.com/gofiber/fiber/v2 v2.52.6
 	github.com/gofiber/template/html/v2 v2.1.3
 	github.com/golang-jwt/jwt/v5 v5.2.1
 	github.com/spf13/cobra v1.8.1
 	github.com/valyala/fasthttp v1.58.0
+	go.etcd.io/bbolt v1.3.11
 	go.uber.org/zap v1.27.0
+	golang.org/x/time v0.10.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
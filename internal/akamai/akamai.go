@@ -0,0 +1,233 @@
+package akamai
+
+import (
+	"akapurgo/api/v1alpha1"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// FastPurgeBodyLimit is the maximum size in bytes Fast Purge accepts for a request body
+	FastPurgeBodyLimit = 50000
+
+	// emptyPayloadSize is the size of the payload without objects: {"objects":[]}
+	emptyPayloadSize = len(`{"objects":[]}`)
+
+	// maxResponseSize is the maximum size in bytes read from the Akamai responses
+	maxResponseSize = 1 << 20
+
+	// maxDetailSize is the maximum size of the non JSON error bodies kept as detail of the problem
+	maxDetailSize = 512
+)
+
+var (
+	// httpClient is shared between all the calls to Akamai as it is safe for concurrent use
+	httpClient = &http.Client{}
+
+	// purgeTypeEndpoints maps the purge types to the object types of the Fast Purge API
+	purgeTypeEndpoints = map[string]string{
+		"urls":       "url",
+		"cache-tags": "tag",
+		"cpcodes":    "cpcode",
+	}
+)
+
+// PurgeURL returns the Fast Purge endpoint for the given purge type, action and environment
+// Ref: https://techdocs.akamai.com/purge-cache/reference/api
+func PurgeURL(host, purgeType, actionType, environment string) (string, error) {
+	endpoint, ok := purgeTypeEndpoints[purgeType]
+	if !ok {
+		return "", fmt.Errorf("unknown purge type '%s'", purgeType)
+	}
+
+	return fmt.Sprintf("%s/ccu/v3/%s/%s/%s", host, actionType, endpoint, environment), nil
+}
+
+// SplitObjects splits the objects into batches whose Fast Purge payload does not exceed the given limit in bytes
+func SplitObjects[T any](objects []T, limit int) (batches [][]T, err error) {
+	var batch []T
+	batchSize := emptyPayloadSize
+
+	for _, object := range objects {
+		objectBytes, err := json.Marshal(object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode object '%v': %v", object, err)
+		}
+
+		// Objects are separated by commas inside the JSON array
+		objectSize := len(objectBytes)
+		if len(batch) > 0 {
+			objectSize++
+		}
+
+		if emptyPayloadSize+len(objectBytes) > limit {
+			return nil, fmt.Errorf("object '%v' does not fit in a single request of %d bytes", object, limit)
+		}
+
+		if batchSize+objectSize > limit {
+			batches = append(batches, batch)
+			batch = nil
+			batchSize = emptyPayloadSize
+			objectSize = len(objectBytes)
+		}
+
+		batch = append(batch, object)
+		batchSize += objectSize
+	}
+
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches, nil
+}
+
+// Payload returns the JSON body of the Fast Purge request for the given objects
+func Payload[T any](objects []T) ([]byte, error) {
+	payloadBytes, err := json.Marshal(map[string]interface{}{
+		"objects": objects,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %v", err)
+	}
+
+	return payloadBytes, nil
+}
+
+// Purge sends a purge request for the given objects to the Fast Purge endpoint, signed with the credentials
+// of the given account. It is retried with backoff when Akamai is rate limiting, failing or not answering
+// within the request timeout. The number of attempts is returned along with the last response.
+// When Akamai does not report the status in the body, the status code of the HTTP response is used
+func Purge[T any](ctx v1alpha1.Context, credentials *Credentials, account string, purgeURL string, objects []T) (akamaiResp v1alpha1.AkamaiResponse, attempts int, err error) {
+
+	payloadBytes, err := Payload(objects)
+	if err != nil {
+		return akamaiResp, attempts, err
+	}
+
+	retry := ctx.Config().Akamai.Retry
+	maxAttempts := max(retry.MaxAttempts, 1)
+	for attempts = 1; ; attempts++ {
+		var header http.Header
+		akamaiResp, header, err = send(credentials, account, purgeURL, payloadBytes, ctx.Config().Akamai.RequestTimeout)
+
+		ctx.Logger.Infof("akamai-attempt,url='%s',attempt=%d/%d,status=%d,error='%v'",
+			purgeURL, attempts, maxAttempts, akamaiResp.HTTPStatus, err)
+
+		if !isRetryable(akamaiResp.HTTPStatus, err) || attempts >= maxAttempts {
+			return akamaiResp, attempts, err
+		}
+
+		// Wait for the time requested by Akamai, or use exponential backoff when it is not requested.
+		// Retrying is not worth it when Akamai asks for a longer wait than the maximum backoff
+		wait, requested := retryAfter(header, time.Now())
+		if !requested {
+			wait = backoff(attempts, retry.InitialBackoff, retry.MaxBackoff)
+		}
+		if wait > retry.MaxBackoff {
+			ctx.Logger.Warnf("Akamai requested to wait %s before retrying, which exceeds the maximum backoff", wait)
+			return akamaiResp, attempts, err
+		}
+
+		time.Sleep(wait)
+	}
+}
+
+// send sends a single purge request with the given payload to Akamai, signed with the credentials of the account.
+// The whole request, reading the response included, is limited to the timeout
+func send(credentials *Credentials, account string, purgeURL string, payloadBytes []byte,
+	timeout time.Duration) (akamaiResp v1alpha1.AkamaiResponse, header http.Header, err error) {
+
+	requestCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Create the HTTP request to Akamai
+	apiRequest, err := http.NewRequestWithContext(requestCtx, "POST", purgeURL, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return akamaiResp, header, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	// Generate the Authorization header with the EdgeGrid signer of the account
+	// https://github.com/akamai/AkamaiOPEN-edgegrid-golang
+	if err := credentials.Sign(account, apiRequest); err != nil {
+		return akamaiResp, header, fmt.Errorf("failed to sign the request with given credentials: %v", err)
+	}
+
+	// Set required headers
+	apiRequest.Header.Set("Content-Type", "application/json")
+
+	// Send the request to Akamai
+	resp, err := httpClient.Do(apiRequest)
+	if err != nil {
+		return akamaiResp, header, fmt.Errorf("failed to communicate with Akamai: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the whole body, as errors are not always JSON
+	// The status is kept along with the errors, as purges accepted by Akamai must not be sent again
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		akamaiResp.HTTPStatus = resp.StatusCode
+		return akamaiResp, resp.Header, fmt.Errorf("failed to read Akamai response: %v", err)
+	}
+
+	akamaiResp, err = decodeResponse(resp, body)
+	return akamaiResp, resp.Header, err
+}
+
+// decodeResponse decodes the Akamai response. Successful responses are decoded as they are, while errors
+// are parsed as problem+json. Bodies that can not be parsed are kept as the detail of the problem
+func decodeResponse(resp *http.Response, body []byte) (akamaiResp v1alpha1.AkamaiResponse, err error) {
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if err := json.Unmarshal(body, &akamaiResp); err != nil {
+			return v1alpha1.AkamaiResponse{HTTPStatus: resp.StatusCode},
+				fmt.Errorf("purge accepted, but failed to decode Akamai response: %v", err)
+		}
+
+		if akamaiResp.HTTPStatus == 0 {
+			akamaiResp.HTTPStatus = resp.StatusCode
+		}
+		return akamaiResp, nil
+	}
+
+	// Fast Purge errors carry both the problem+json fields and the Fast Purge ones (httpStatus, supportId...)
+	problem := &v1alpha1.AkamaiProblem{}
+	if err := json.Unmarshal(body, problem); err != nil {
+		detail := strings.TrimSpace(string(body))
+		if len(detail) > maxDetailSize {
+			detail = detail[:maxDetailSize] + "..."
+		}
+		problem = &v1alpha1.AkamaiProblem{
+			Title:  http.StatusText(resp.StatusCode),
+			Detail: detail,
+		}
+	}
+	_ = json.Unmarshal(body, &akamaiResp)
+
+	if problem.Status == 0 {
+		problem.Status = resp.StatusCode
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+
+	akamaiResp.Error = problem
+	if akamaiResp.HTTPStatus == 0 {
+		akamaiResp.HTTPStatus = problem.Status
+	}
+	if akamaiResp.Title == "" {
+		akamaiResp.Title = problem.Title
+	}
+	if akamaiResp.Detail == "" {
+		akamaiResp.Detail = problem.Detail
+	}
+
+	return akamaiResp, nil
+}
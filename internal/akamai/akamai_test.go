@@ -0,0 +1,116 @@
+package akamai
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/config"
+	"akapurgo/internal/secrets"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestPurge returns a context and credentials for the Fast Purge endpoint at the given host,
+// retrying without waiting and giving up on attempts quickly
+func newTestPurge(t *testing.T, host string) (v1alpha1.Context, *Credentials) {
+	t.Helper()
+
+	configContent := &v1alpha1.ConfigSpec{}
+	configContent.Akamai.Host = host
+	configContent.Akamai.ClientSecret = "secret"
+	configContent.Akamai.ClientToken = "client-token"
+	configContent.Akamai.AccessToken = "access-token"
+	configContent.Akamai.RequestTimeout = 200 * time.Millisecond
+	configContent.Akamai.Retry.InitialBackoff = time.Millisecond
+	configContent.Akamai.Retry.MaxBackoff = time.Millisecond
+	config.SetDefaults(configContent)
+
+	credentials, err := NewCredentials(configContent, secrets.NewResolver(configContent.Secrets))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return v1alpha1.NewContext(configContent, zap.NewNop().Sugar()), credentials
+}
+
+func TestPurgeRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // Status of each attempt, the last one repeated
+		body     string
+		status   int
+		attempts int
+		err      bool
+	}{
+		{"accepted", []int{http.StatusCreated}, `{"httpStatus":201,"detail":"Request accepted"}`,
+			http.StatusCreated, 1, false},
+		{"accepted with undecodable body", []int{http.StatusCreated}, `<html>accepted</html>`,
+			http.StatusCreated, 1, true},
+		{"rate limited then accepted", []int{http.StatusTooManyRequests, http.StatusCreated},
+			`{"detail":"Request accepted"}`, http.StatusCreated, 2, false},
+		{"failing", []int{http.StatusBadGateway}, `bad gateway`, http.StatusBadGateway, 3, false},
+		{"rejected", []int{http.StatusForbidden}, `{"title":"Forbidden"}`, http.StatusForbidden, 1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				call := int(calls.Add(1))
+				w.WriteHeader(test.statuses[min(call, len(test.statuses))-1])
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			ctx, credentials := newTestPurge(t, server.URL)
+			purgeURL, _ := PurgeURL(server.URL, "urls", "invalidate", "staging")
+			response, attempts, err := Purge(ctx, credentials, DefaultAccount, purgeURL, []string{"https://www.example.com/"})
+
+			if response.HTTPStatus != test.status {
+				t.Errorf("expected status %d, got %d", test.status, response.HTTPStatus)
+			}
+			if attempts != test.attempts || int(calls.Load()) != test.attempts {
+				t.Errorf("expected %d attempts, got %d for %d calls", test.attempts, attempts, calls.Load())
+			}
+			if (err != nil) != test.err {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestPurgeRetriesUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	ctx, credentials := newTestPurge(t, server.URL)
+	purgeURL, _ := PurgeURL(server.URL, "urls", "invalidate", "staging")
+	_, attempts, err := Purge(ctx, credentials, DefaultAccount, purgeURL, []string{"https://www.example.com/"})
+
+	if err == nil || attempts != ctx.Config().Akamai.Retry.MaxAttempts {
+		t.Errorf("expected %d attempts failing, got %d: %v", ctx.Config().Akamai.Retry.MaxAttempts, attempts, err)
+	}
+}
+
+func TestPurgeRetriesHanging(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, credentials := newTestPurge(t, server.URL)
+	purgeURL, _ := PurgeURL(server.URL, "urls", "invalidate", "staging")
+	_, attempts, err := Purge(ctx, credentials, DefaultAccount, purgeURL, []string{"https://www.example.com/"})
+
+	maxAttempts := ctx.Config().Akamai.Retry.MaxAttempts
+	if err == nil || attempts != maxAttempts || int(calls.Load()) != maxAttempts {
+		t.Errorf("expected %d attempts timing out, got %d for %d calls: %v", maxAttempts, attempts, calls.Load(), err)
+	}
+}
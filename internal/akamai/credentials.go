@@ -0,0 +1,292 @@
+package akamai
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/secrets"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/akamai/AkamaiOPEN-edgegrid-golang/v9/pkg/edgegrid"
+)
+
+const (
+	// DefaultAccount is the name of the account of the credentials set directly under akamai
+	DefaultAccount = "default"
+)
+
+var (
+	ErrUnknownAccount = errors.New("unknown Akamai account")
+	ErrMixedAccounts  = errors.New("paths belong to several Akamai accounts")
+)
+
+// Credentials holds the Akamai accounts along with their EdgeGrid signers. They are built in memory from
+// the configuration, the secret references and the edgerc files it uses, so nothing is written to disk.
+// Refresh builds them again to pick up rotated secrets
+type Credentials struct {
+	config   *v1alpha1.ConfigSpec
+	resolver *secrets.Resolver
+
+	mutex    sync.RWMutex
+	accounts []v1alpha1.AkamaiAccount
+	signers  map[string]*edgegrid.Config
+}
+
+// NewCredentials builds the signers of all the accounts of the configuration, starting with the default one.
+// Accounts without their own credentials inherit the default ones
+func NewCredentials(config *v1alpha1.ConfigSpec, resolver *secrets.Resolver) (*Credentials, error) {
+	credentials := &Credentials{config: config, resolver: resolver}
+
+	accounts, signers, err := credentials.load()
+	if err != nil {
+		return nil, err
+	}
+
+	credentials.accounts = accounts
+	credentials.signers = signers
+	return credentials, nil
+}
+
+// load resolves the secrets of the accounts and builds their signers
+func (c *Credentials) load() (accounts []v1alpha1.AkamaiAccount, signers map[string]*edgegrid.Config, err error) {
+	defaultAccount := v1alpha1.AkamaiAccount{
+		Name:             DefaultAccount,
+		Host:             c.config.Akamai.Host,
+		ClientSecret:     c.config.Akamai.ClientSecret,
+		ClientToken:      c.config.Akamai.ClientToken,
+		AccessToken:      c.config.Akamai.AccessToken,
+		Edgerc:           c.config.Akamai.Edgerc,
+		Section:          c.config.Akamai.Section,
+		AccountSwitchKey: c.config.Akamai.AccountSwitchKey,
+	}
+	if err := c.resolveSecrets(&defaultAccount); err != nil {
+		return nil, nil, fmt.Errorf("invalid credentials for Akamai account '%s': %v", DefaultAccount, err)
+	}
+
+	signers = map[string]*edgegrid.Config{}
+	for index, account := range append([]v1alpha1.AkamaiAccount{defaultAccount}, c.config.Akamai.Accounts...) {
+		if account.Name == "" {
+			return nil, nil, fmt.Errorf("Akamai account %d requires a name", index)
+		}
+		if _, found := signers[account.Name]; found {
+			return nil, nil, fmt.Errorf("Akamai account '%s' is defined twice", account.Name)
+		}
+
+		if index > 0 {
+			if err := c.resolveSecrets(&account); err != nil {
+				return nil, nil, fmt.Errorf("invalid credentials for Akamai account '%s': %v", account.Name, err)
+			}
+		}
+
+		if account.ClientToken == "" && account.Edgerc == "" {
+			account.Host = defaultAccount.Host
+			account.ClientSecret = defaultAccount.ClientSecret
+			account.ClientToken = defaultAccount.ClientToken
+			account.AccessToken = defaultAccount.AccessToken
+			account.Edgerc = defaultAccount.Edgerc
+			account.Section = defaultAccount.Section
+		}
+
+		signer, err := newSigner(&account)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid credentials for Akamai account '%s': %v", account.Name, err)
+		}
+
+		accounts = append(accounts, account)
+		signers[account.Name] = signer
+	}
+
+	return accounts, signers, nil
+}
+
+// resolveSecrets replaces the secret references of the account credentials with the secrets
+func (c *Credentials) resolveSecrets(account *v1alpha1.AkamaiAccount) (err error) {
+	for _, field := range []*string{&account.ClientSecret, &account.ClientToken, &account.AccessToken} {
+		if *field, err = c.resolver.Resolve(*field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Refresh builds the credentials again, and swaps them when the secrets or edgerc files changed.
+// The current credentials are kept when the new ones are invalid
+func (c *Credentials) Refresh() (changed bool, err error) {
+	c.mutex.RLock()
+	next := &Credentials{config: c.config, resolver: c.resolver}
+	c.mutex.RUnlock()
+
+	accounts, signers, err := next.load()
+	if err != nil {
+		return false, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// The credentials were replaced by a reload meanwhile
+	if c.config != next.config {
+		return false, nil
+	}
+
+	if reflect.DeepEqual(accounts, c.accounts) && reflect.DeepEqual(signers, c.signers) {
+		return false, nil
+	}
+
+	c.accounts = accounts
+	c.signers = signers
+	return true, nil
+}
+
+// Replace swaps in the configuration, resolver and signers of other credentials, built from a reloaded configuration
+func (c *Credentials) Replace(other *Credentials) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.config = other.config
+	c.resolver = other.resolver
+	c.accounts = other.accounts
+	c.signers = other.signers
+}
+
+// Watch refreshes the credentials every secrets.refresh_interval until the process exits
+func (c *Credentials) Watch(ctx v1alpha1.Context) {
+	for {
+		c.mutex.RLock()
+		interval := c.config.Secrets.RefreshInterval
+		c.mutex.RUnlock()
+
+		time.Sleep(interval)
+
+		changed, err := c.Refresh()
+		if err != nil {
+			ctx.Logger.Errorf("Failed to refresh Akamai credentials, keeping the current ones: %v", err)
+			continue
+		}
+
+		if changed {
+			ctx.Logger.Infof("Akamai credentials changed, reloaded %d accounts", len(c.Accounts()))
+		}
+	}
+}
+
+// newSigner builds the EdgeGrid signer of an account. The host of the credentials loaded from an edgerc file
+// is set back in the account
+func newSigner(account *v1alpha1.AkamaiAccount) (*edgegrid.Config, error) {
+	signer := &edgegrid.Config{
+		Host:         account.Host,
+		ClientToken:  account.ClientToken,
+		ClientSecret: account.ClientSecret,
+		AccessToken:  account.AccessToken,
+		MaxBody:      edgegrid.MaxBodySize,
+	}
+
+	if account.Edgerc != "" {
+		section := account.Section
+		if section == "" {
+			section = edgegrid.DefaultSection
+		}
+
+		var err error
+		signer, err = edgegrid.New(edgegrid.WithFile(account.Edgerc), edgegrid.WithSection(section))
+		if err != nil {
+			return nil, err
+		}
+
+		// Hosts in edgerc files come without scheme
+		account.Host = signer.Host
+		if !strings.Contains(account.Host, "://") {
+			account.Host = "https://" + account.Host
+		}
+	}
+
+	if account.AccountSwitchKey != "" {
+		signer.AccountKey = account.AccountSwitchKey
+	}
+
+	if account.Host == "" || signer.ClientToken == "" || signer.ClientSecret == "" || signer.AccessToken == "" {
+		return nil, errors.New("host, client_secret, client_token and access_token are required")
+	}
+
+	return signer, signer.Validate()
+}
+
+// Accounts returns the accounts, starting with the default one
+func (c *Credentials) Accounts() []v1alpha1.AkamaiAccount {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.accounts
+}
+
+// Sign adds the EdgeGrid authorization header of the account to the request
+func (c *Credentials) Sign(account string, request *http.Request) error {
+	c.mutex.RLock()
+	signer, ok := c.signers[account]
+	c.mutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%w: '%s'", ErrUnknownAccount, account)
+	}
+
+	signer.SignRequest(request)
+	return nil
+}
+
+// Resolve returns the account a purge request is sent with. The account requested by name wins,
+// otherwise URLs are routed by their hostnames. Everything else goes to the default account
+func (c *Credentials) Resolve(req v1alpha1.PurgeRequest) (account v1alpha1.AkamaiAccount, err error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if req.Account != "" {
+		for _, account := range c.accounts {
+			if account.Name == req.Account {
+				return account, nil
+			}
+		}
+		return account, fmt.Errorf("%w: '%s'", ErrUnknownAccount, req.Account)
+	}
+
+	if req.PurgeType != "urls" {
+		return c.accounts[0], nil
+	}
+
+	// Every URL must be routed to the same account, as a single Fast Purge call can only use one
+	resolved := -1
+	for _, entry := range req.Paths {
+		index := accountForURL(c.accounts, entry)
+		if resolved >= 0 && index != resolved {
+			return account, fmt.Errorf("%w: '%s' and '%s'", ErrMixedAccounts, c.accounts[resolved].Name, c.accounts[index].Name)
+		}
+		resolved = index
+	}
+
+	return c.accounts[max(resolved, 0)], nil
+}
+
+// accountForURL returns the index of the first account whose hostnames match the URL, or the default one
+func accountForURL(accounts []v1alpha1.AkamaiAccount, rawURL string) int {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return 0
+	}
+	hostname := strings.ToLower(parsedURL.Hostname())
+
+	for index, account := range accounts {
+		for _, pattern := range account.Hostnames {
+			if matched, _ := path.Match(strings.ToLower(pattern), hostname); matched {
+				return index
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,71 @@
+package akamai
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// isRetryable returns whether a purge request should be retried: when Akamai is rate limiting (429),
+// failing (5xx) or could not be reached at all. Other errors, like responses that can not be read,
+// are not retried as Akamai may have accepted the purge
+func isRetryable(status int, err error) bool {
+	if status == 0 {
+		var urlErr *url.Error
+		return errors.As(err, &urlErr)
+	}
+
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// backoff returns the time to wait before the next attempt using exponential backoff with jitter.
+// The wait is randomized between the half and the whole of the exponential value, capped to maxBackoff
+func backoff(attempt int, initialBackoff, maxBackoff time.Duration) time.Duration {
+	wait := initialBackoff << (attempt - 1)
+	if wait <= 0 || wait > maxBackoff {
+		wait = maxBackoff
+	}
+
+	half := wait / 2
+	if half <= 0 {
+		return wait
+	}
+
+	return half + rand.N(half+1)
+}
+
+// retryAfter returns the time Akamai asked to wait before retrying, when it is present in the headers.
+// Supported headers are Retry-After (seconds or HTTP date), X-RateLimit-Next (RFC 3339 timestamp)
+// and X-RateLimit-Reset (seconds)
+func retryAfter(header http.Header, now time.Time) (wait time.Duration, requested bool) {
+	if header == nil {
+		return wait, false
+	}
+
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+
+	if value := header.Get("X-RateLimit-Next"); value != "" {
+		if date, err := time.Parse(time.RFC3339, value); err == nil {
+			return max(date.Sub(now), 0), true
+		}
+	}
+
+	// Only consider the reset of the rate limit when the limit has been reached
+	if header.Get("X-RateLimit-Remaining") == "0" {
+		if seconds, err := strconv.Atoi(header.Get("X-RateLimit-Reset")); err == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	return wait, false
+}
@@ -2,27 +2,38 @@ package api
 
 import (
 	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/approval"
+	"akapurgo/internal/auth"
 	"akapurgo/internal/commons"
-	"bytes"
+	"akapurgo/internal/jobs"
+	"akapurgo/internal/policy"
+	"akapurgo/internal/purge"
+	"akapurgo/internal/ratelimit"
+	"akapurgo/internal/storage"
+	"akapurgo/internal/validation"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"math"
+	"slices"
+	"strconv"
 	"time"
 
-	"github.com/akamai/AkamaiOPEN-edgegrid-golang/v9/pkg/edgegrid"
 	"github.com/gofiber/fiber/v2"
 )
 
-var (
-	akamaiResp v1alpha1.AkamaiResponse
-	req        v1alpha1.PurgeRequest
-	purgeURL   string
-	resp       *http.Response
-)
-
-func PurgeHandler(ctx v1alpha1.Context) func(c *fiber.Ctx) error {
+// PurgeHandler handles the purge requests sent to the API.
+// Each request keeps its own state, so the handler is safe under concurrent requests.
+// When the query parameter async is true, the purge runs in background and a job is returned right away.
+// Purges needing approval are stored as pending and only run once approved.
+// Dry runs return the plan of the purge without calling Akamai
+// Every purge is recorded in the history along with the user who made it
+func PurgeHandler(ctx v1alpha1.Context, purger *purge.Purger, jobStore *jobs.Store, store storage.Store) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
+		// Purges are recorded at the time they were requested, not when they finished
+		requestedAt := time.Now()
+
+		var req v1alpha1.PurgeRequest
 
 		// Verify the Content-Type header
 		if c.Get("Content-Type") != "application/json" {
@@ -40,129 +51,225 @@ func PurgeHandler(ctx v1alpha1.Context) func(c *fiber.Ctx) error {
 			})
 		}
 
-		// Parse the JSON body from the request and validate the body
+		// Parse the JSON body from the request
 		if err := c.BodyParser(&req); err != nil {
 			ctx.Logger.Errorf("Failed to parse request: %v\n", err)
-			return c.Status(fiber.StatusBadRequest).JSON(map[string]string{
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error": "Invalid request payload",
+				"errors": []v1alpha1.ValidationError{
+					{Field: "body", Message: err.Error()},
+				},
 			})
 		}
 
-		// Determine the Akamai API URL
-		if req.PurgeType == "urls" {
-			purgeURL = fmt.Sprintf("%s/ccu/v3/%s/url/%s", ctx.Config.Akamai.Host, req.ActionType, req.Environment)
-		} else if req.PurgeType == "cache-tags" {
-			purgeURL = fmt.Sprintf("%s/ccu/v3/%s/tag/%s", ctx.Config.Akamai.Host, req.ActionType, req.Environment)
-		} else {
-			ctx.Logger.Error("Invalid purge type")
-			return c.Status(fiber.StatusBadRequest).JSON(map[string]string{
-				"error": "Invalid purge type",
+		// Validate every field of the request before building anything for Akamai
+		if validationErrors := validation.ValidatePurgeRequest(req); len(validationErrors) > 0 {
+			ctx.Logger.Errorf("Invalid request payload: %d wrong fields", len(validationErrors))
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":  "Invalid request payload",
+				"errors": validationErrors,
 			})
 		}
 
-		// Create the payload for Akamai
-		akamaiPayload := map[string]interface{}{
-			"objects": req.Paths,
-		}
+		user := commons.GetUser(ctx, c)
 
-		// Marshal the payload to JSON
-		payloadBytes, err := json.Marshal(akamaiPayload)
-		if err != nil {
-			ctx.Logger.Errorf("Failed to marshal payload: %v\n", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(map[string]string{
-				"error": "Failed to encode payload",
+		// API keys must be granted the scope of the purge type
+		identity, authenticated := commons.GetIdentity(c)
+		if !auth.HasScope(identity, "purge:"+req.PurgeType) {
+			ctx.Logger.Warnf("API key '%s' lacks the scope 'purge:%s'", identity.User, req.PurgeType)
+			return c.Status(fiber.StatusForbidden).JSON(map[string]string{
+				"error": fmt.Sprintf("API key '%s' lacks the scope 'purge:%s'", identity.User, req.PurgeType),
 			})
 		}
 
-		// Create the HTTP request to Akamai
-		client := &http.Client{}
-		apiRequest, err := http.NewRequest("POST", purgeURL, bytes.NewReader(payloadBytes))
-		if err != nil {
-			ctx.Logger.Errorf("Failed to create HTTP request: %v\n", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(map[string]string{
-				"error": "Failed to create request",
+		// Check what the caller may purge. Every decision is logged
+		decision := policy.Evaluate(ctx, identity, req)
+		ctx.Logger.Infof("policy-decision,user='%s',purgeType='%s',actionType='%s',environment='%s',allowed=%t,rule='%s',path='%s',reason='%s'",
+			identity.User, req.PurgeType, req.ActionType, req.Environment, decision.Allowed, decision.Rule, decision.Path,
+			decision.Reason)
+		if !decision.Allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":  "Forbidden by policy",
+				"rule":   decision.Rule,
+				"path":   decision.Path,
+				"reason": decision.Reason,
 			})
 		}
 
-		// Generate the Authorization header with the edgerc Akamai library and the configuration file
-		// generated previously or loaded from the environment
-		// https://github.com/akamai/AkamaiOPEN-edgegrid-golang
-		edgerc, err := edgegrid.New(edgegrid.WithFile(commons.AkamaiConfigPath))
-		if err != nil {
-			ctx.Logger.Errorf("Failed to sign the request with given credentials: %v\n", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(map[string]string{
-				"error": "Failed to sign the request with given credentials",
-			})
+		// Check the CP codes against the allowlist of the teams the user belongs to
+		if req.PurgeType == "cpcodes" {
+			// The CP codes were already checked by the validation of the request
+			cpCodes, _ := purge.ParseCPCodes(req.Paths)
+			if denied := deniedCPCodes(ctx, identity, cpCodes); len(denied) > 0 {
+				ctx.Logger.Errorf("CP codes %v are not allowed for user '%s'", denied, identity.User)
+				return c.Status(fiber.StatusForbidden).JSON(map[string]string{
+					"error": fmt.Sprintf("CP codes %v are not allowed", denied),
+				})
+			}
 		}
-		edgerc.SignRequest(apiRequest)
 
-		// Set required headers
-		apiRequest.Header.Set("Content-Type", "application/json")
+		// Dry runs go through every check, then return what the purge would do without calling Akamai
+		if req.DryRun {
+			plan, err := purger.Plan(req)
+			if err != nil {
+				return purgeError(ctx, c, err)
+			}
+			plan.ApprovalRequired, _ = approval.Required(ctx, req)
 
-		// Send the request to Akamai
-		resp, err = client.Do(apiRequest)
-		if err != nil {
-			ctx.Logger.Errorf("Failed to send request to Akamai: %v\n", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(map[string]string{
-				"error": "Failed to communicate with Akamai",
-			})
+			ctx.Logger.Infof("purge-dry-run,user='%s',purgeType='%s',calls=%d", user, req.PurgeType, len(plan.Calls))
+			return c.JSON(plan)
+		}
+
+		// Purges matching an approval rule wait in the history for a second user to approve them.
+		// The requester must be authenticated, so that they can not review their own purge as someone else
+		if rule, required := approval.Required(ctx, req); required {
+			if !authenticated || identity.User == "" {
+				ctx.Logger.Warnf("Purge needing approval by rule '%s' requested without authentication", rule)
+				return c.Status(fiber.StatusUnauthorized).JSON(map[string]string{
+					"error": "Authentication required to request an approval",
+				})
+			}
+
+			record, err := requestApproval(ctx, store, identity.User, req, rule, requestedAt)
+			if err != nil {
+				ctx.Logger.Errorf("Failed to request approval: %v\n", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(map[string]string{
+					"error": "Failed to request approval",
+				})
+			}
+
+			ctx.Logger.Infof("purge-approval,id='%s',user='%s',rule='%s',status='%s'", record.ID, identity.User, rule,
+				record.Status)
+			return c.Status(fiber.StatusAccepted).JSON(record)
 		}
 
-		defer resp.Body.Close()
+		// Run the purge in background, the client follows it through the job status endpoint
+		if c.QueryBool("async") {
+			job, err := jobStore.Create(req)
+			if err != nil {
+				ctx.Logger.Errorf("Failed to create purge job: %v\n", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(map[string]string{
+					"error": "Failed to create purge job",
+				})
+			}
 
-		// Decode the Akamai response
-		if err := json.NewDecoder(resp.Body).Decode(&akamaiResp); err != nil {
-			ctx.Logger.Errorf("Failed to decode Akamai response: %v\n", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(map[string]string{
-				"error": "Failed to decode Akamai response",
+			go runJob(ctx, purger, jobStore, job.ID, req, func(response v1alpha1.PurgeResponse, err error) {
+				recordPurge(ctx, store, user, req, requestedAt, response, err)
 			})
+
+			ctx.Logger.Infof("purge-job,id='%s',stage='%s'", job.ID, job.Stage)
+			return c.Status(fiber.StatusAccepted).JSON(job)
 		}
 
-		// Send a GET requests to purged URLs
-		if is2xx(akamaiResp.HTTPStatus) && req.PostPurgeRequest && ctx.Config.PostPurgeRequest.Enabled {
-			time.Sleep(5 * time.Second) // Wait for 5 seconds before sending GET requests
-			executePurgeRequest(req.Paths, ctx)
+		response, err := purger.Run(req, nil)
+		recordPurge(ctx, store, user, req, requestedAt, response, err)
+		if err != nil {
+			return purgeError(ctx, c, err)
 		}
 
-		// Forward the Akamai response to the client
-		ctx.Logger.Infof(`akamai-response,detail='%s',status=%d`, akamaiResp.Detail, akamaiResp.HTTPStatus)
-		return c.Status(resp.StatusCode).JSON(akamaiResp)
+		// Forward the combined Akamai response to the client
+		return c.Status(response.HTTPStatus).JSON(response)
 	}
 }
 
-func executePurgeRequest(paths []string, ctx v1alpha1.Context) {
-	client := &http.Client{}
+// JobHandler returns the status of a purge job
+func JobHandler(ctx v1alpha1.Context, jobStore *jobs.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		job, ok := jobStore.Get(c.Params("id"))
+		if !ok {
+			ctx.Logger.Errorf("Purge job '%s' not found", c.Params("id"))
+			return c.Status(fiber.StatusNotFound).JSON(map[string]string{
+				"error": "Purge job not found",
+			})
+		}
 
-	for _, path := range paths {
-		// Create the HTTP GET request
-		getRequest, err := http.NewRequest("GET", path, nil)
+		return c.JSON(job)
+	}
+}
+
+// runJob runs the purge of a job, keeping its stage and progress up to date in the store.
+// The outcome of the purge is passed to record before the job is done, to keep it in the history
+func runJob(ctx v1alpha1.Context, purger *purge.Purger, jobStore *jobs.Store, id string, req v1alpha1.PurgeRequest,
+	record func(response v1alpha1.PurgeResponse, err error)) {
+	response, err := purger.Run(req, func(stage string, done, total int) {
+		jobStore.Update(id, func(job *v1alpha1.PurgeJob) {
+			job.Stage = stage
+			job.Progress = v1alpha1.JobProgress{Done: done, Total: total}
+		})
+	})
+	record(response, err)
+
+	jobStore.Update(id, func(job *v1alpha1.PurgeJob) {
+		job.Stage = v1alpha1.JobStageDone
 		if err != nil {
-			ctx.Logger.Errorf("Failed to create GET request for %s: %v\n", path, err)
-			continue
+			job.Stage = v1alpha1.JobStageFailed
+			job.Error = err.Error()
+			return
 		}
 
-		// Add custom headers from configuration
-		for key, value := range ctx.Config.PostPurgeRequest.Headers {
-			getRequest.Header.Set(key, value)
+		job.Response = &response
+		if !is2xx(response.HTTPStatus) {
+			job.Stage = v1alpha1.JobStageFailed
+			job.Error = response.Detail
 		}
+	})
 
-		// Send the GET request
-		response, err := client.Do(getRequest)
-		if err != nil {
-			ctx.Logger.Errorf("Failed to send GET request to %s: %v\n", path, err)
+	job, _ := jobStore.Get(id)
+	ctx.Logger.Infof("purge-job,id='%s',stage='%s',error='%s'", job.ID, job.Stage, job.Error)
+}
+
+// purgeError answers the client with the status matching an error returned by the purger
+func purgeError(ctx v1alpha1.Context, c *fiber.Ctx, err error) error {
+	var rateLimitErr *ratelimit.RateLimitError
+	switch {
+	case errors.As(err, &rateLimitErr):
+		ctx.Logger.Warnf("Purge request rejected: %v\n", err)
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(rateLimitErr.RetryAfter.Seconds()))))
+		return c.Status(fiber.StatusTooManyRequests).JSON(map[string]string{
+			"error": err.Error(),
+		})
+	case errors.Is(err, purge.ErrInvalidRequest):
+		ctx.Logger.Errorf("Invalid purge request: %v\n", err)
+		return c.Status(fiber.StatusBadRequest).JSON(map[string]string{
+			"error": err.Error(),
+		})
+	default:
+		ctx.Logger.Errorf("Failed to purge: %v\n", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(map[string]string{
+			"error": "Failed to purge",
+		})
+	}
+}
+
+// deniedCPCodes returns the CP codes that are not allowed for the verified identity of the caller.
+// When no team is configured, all the CP codes are allowed. Otherwise, callers without identity are denied them all
+func deniedCPCodes(ctx v1alpha1.Context, identity v1alpha1.Identity, cpCodes []int) (denied []int) {
+	teams := ctx.Config().CPCodes.Teams
+	if len(teams) == 0 {
+		return denied
+	}
+
+	if identity.User == "" {
+		return cpCodes
+	}
+
+	allowed := map[int]bool{}
+	for _, team := range teams {
+		if !slices.Contains(team.Users, identity.User) && !slices.Contains(team.Users, "*") {
 			continue
 		}
-
-		// Read and discard the body to complete the request properly
-		_, err = io.ReadAll(response.Body)
-		if err != nil {
-			ctx.Logger.Warnf("Failed to read response body from %s: %v\n", path, err)
+		for _, cpCode := range team.CPCodes {
+			allowed[cpCode] = true
 		}
-		response.Body.Close()
+	}
 
-		// Log the response status
-		ctx.Logger.Infof("GET request to %s returned status code %d\n", path, response.StatusCode)
+	for _, cpCode := range cpCodes {
+		if !allowed[cpCode] {
+			denied = append(denied, cpCode)
+		}
 	}
+
+	return denied
 }
 
 func is2xx(status int) bool {
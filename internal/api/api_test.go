@@ -0,0 +1,168 @@
+package api
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/akamai"
+	"akapurgo/internal/config"
+	"akapurgo/internal/purge"
+	"akapurgo/internal/ratelimit"
+	"akapurgo/internal/secrets"
+	"akapurgo/internal/storage"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// concurrentPurges is the number of purge requests sent at once to the handler
+const concurrentPurges = 50
+
+// fakeCCU is a Fast Purge endpoint accepting every purge. The purge ID of a response is the single object
+// of its request, so that every response can be matched to the request it answers
+type fakeCCU struct {
+	server *httptest.Server
+
+	mutex    sync.Mutex
+	payloads map[string]int // Number of requests received by object
+}
+
+func newFakeCCU(t *testing.T) *fakeCCU {
+	t.Helper()
+
+	ccu := &fakeCCU{payloads: map[string]int{}}
+	ccu.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Objects []string `json:"objects"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || len(payload.Objects) != 1 {
+			t.Errorf("unexpected payload: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "EG1-HMAC-SHA256 ") {
+			t.Errorf("request for %s not signed", payload.Objects[0])
+		}
+
+		// Interleave the responses of the concurrent requests
+		time.Sleep(time.Duration(rand.IntN(20)) * time.Millisecond)
+
+		ccu.mutex.Lock()
+		ccu.payloads[payload.Objects[0]]++
+		ccu.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(v1alpha1.AkamaiResponse{
+			HTTPStatus:       http.StatusCreated,
+			Detail:           "Request accepted",
+			EstimatedSeconds: 5,
+			PurgeID:          payload.Objects[0],
+			SupportID:        "support-" + payload.Objects[0],
+		})
+	}))
+	t.Cleanup(ccu.server.Close)
+
+	return ccu
+}
+
+func TestPurgeHandlerConcurrency(t *testing.T) {
+	ccu := newFakeCCU(t)
+
+	configContent := &v1alpha1.ConfigSpec{}
+	configContent.Akamai.Host = ccu.server.URL
+	configContent.Akamai.ClientSecret = "secret"
+	configContent.Akamai.ClientToken = "client-token"
+	configContent.Akamai.AccessToken = "access-token"
+	configContent.History.Enabled = true
+	configContent.History.Path = filepath.Join(t.TempDir(), "history.db")
+	config.SetDefaults(configContent)
+	ctx := v1alpha1.NewContext(configContent, zap.NewNop().Sugar())
+
+	credentials, err := akamai.NewCredentials(configContent, secrets.NewResolver(configContent.Secrets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewStore(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	app := fiber.New()
+	app.Post("/api/v1/purge", PurgeHandler(ctx, purge.NewPurger(ctx, ratelimit.NewLimiter(ctx), credentials), nil, store))
+
+	var wg sync.WaitGroup
+	for index := range concurrentPurges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			path := fmt.Sprintf("https://www.example.com/page-%d", index)
+			body, _ := json.Marshal(v1alpha1.PurgeRequest{
+				PurgeType:   "urls",
+				ActionType:  "invalidate",
+				Environment: "staging",
+				Paths:       []string{path},
+			})
+			req := httptest.NewRequest("POST", "/api/v1/purge", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			response, err := app.Test(req, -1)
+			if err != nil {
+				t.Errorf("%s: %v", path, err)
+				return
+			}
+			defer response.Body.Close()
+
+			var purgeResponse v1alpha1.PurgeResponse
+			if err := json.NewDecoder(response.Body).Decode(&purgeResponse); err != nil {
+				t.Errorf("%s: failed to decode response: %v", path, err)
+				return
+			}
+
+			if response.StatusCode != http.StatusCreated || len(purgeResponse.Batches) != 1 {
+				t.Errorf("%s: unexpected response %d with %d batches", path, response.StatusCode,
+					len(purgeResponse.Batches))
+				return
+			}
+			if batch := purgeResponse.Batches[0]; batch.PurgeID != path || batch.SupportID != "support-"+path {
+				t.Errorf("%s: got the response of another request, purge ID '%s'", path, batch.PurgeID)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Akamai received every path exactly once
+	if len(ccu.payloads) != concurrentPurges {
+		t.Errorf("expected %d distinct payloads, got %d", concurrentPurges, len(ccu.payloads))
+	}
+	for object, count := range ccu.payloads {
+		if count != 1 {
+			t.Errorf("%s: purged %d times", object, count)
+		}
+	}
+
+	// Every record of the history holds its own paths and purge ID
+	records, total, err := store.List(v1alpha1.PurgeFilter{Limit: concurrentPurges * 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != concurrentPurges {
+		t.Errorf("expected %d history records, got %d", concurrentPurges, total)
+	}
+	for _, record := range records {
+		if len(record.Paths) != 1 || len(record.PurgeIDs) != 1 || record.PurgeIDs[0] != record.Paths[0] {
+			t.Errorf("record %s mixes paths %v and purge IDs %v", record.ID, record.Paths, record.PurgeIDs)
+		}
+	}
+}
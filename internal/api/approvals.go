@@ -0,0 +1,145 @@
+package api
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/approval"
+	"akapurgo/internal/commons"
+	"akapurgo/internal/jobs"
+	"akapurgo/internal/purge"
+	"akapurgo/internal/storage"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReviewRequest is the optional body of the approve and reject requests
+type ReviewRequest struct {
+	Comment string `json:"comment"`
+}
+
+// ReviewHandler approves or rejects a purge pending approval. Approved purges run in background
+// as a job, which is returned along with the record so the client can follow it
+func ReviewHandler(ctx v1alpha1.Context, purger *purge.Purger, jobStore *jobs.Store, store storage.Store,
+	approve bool) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if store == nil {
+			return c.Status(fiber.StatusNotFound).JSON(map[string]string{
+				"error": "Approvals are disabled",
+			})
+		}
+
+		var review ReviewRequest
+		if len(c.Body()) > 0 {
+			if err := json.Unmarshal(c.Body(), &review); err != nil {
+				ctx.Logger.Errorf("Failed to parse review: %v\n", err)
+				return c.Status(fiber.StatusBadRequest).JSON(map[string]string{
+					"error": "Invalid request payload",
+				})
+			}
+		}
+
+		identity, _ := commons.GetIdentity(c)
+		record, err := store.Update(c.Params("id"), func(record *v1alpha1.PurgeRecord) error {
+			return approval.Review(ctx, identity, record, approve, review.Comment, time.Now())
+		})
+
+		ctx.Logger.Infof("purge-approval,id='%s',reviewer='%s',approve=%t,status='%s',error='%v'",
+			c.Params("id"), identity.User, approve, record.Status, err)
+
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(map[string]string{
+				"error": "Purge not found",
+			})
+		case errors.Is(err, approval.ErrForbidden):
+			return c.Status(fiber.StatusForbidden).JSON(map[string]string{
+				"error": err.Error(),
+			})
+		case errors.Is(err, approval.ErrNotPending):
+			return c.Status(fiber.StatusConflict).JSON(map[string]string{
+				"error": err.Error(),
+			})
+		case err != nil:
+			ctx.Logger.Errorf("Failed to review purge: %v\n", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(map[string]string{
+				"error": "Failed to review purge",
+			})
+		case record.Status == v1alpha1.PurgeStatusExpired:
+			return c.Status(fiber.StatusConflict).JSON(map[string]string{
+				"error": "Approval expired",
+			})
+		case record.Status == v1alpha1.PurgeStatusRejected:
+			return c.JSON(record)
+		}
+
+		// Run the approved purge, keeping its outcome in the same record
+		req := approval.Request(record)
+		job, err := jobStore.Create(req)
+		if err != nil {
+			ctx.Logger.Errorf("Failed to create purge job: %v\n", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(map[string]string{
+				"error": "Failed to create purge job",
+			})
+		}
+
+		go runJob(ctx, purger, jobStore, job.ID, req, func(response v1alpha1.PurgeResponse, purgeErr error) {
+			_, err := store.Update(record.ID, func(record *v1alpha1.PurgeRecord) error {
+				setPurgeResult(record, response, purgeErr)
+				return nil
+			})
+			if err != nil {
+				ctx.Logger.Errorf("Failed to store purge in history: %v\n", err)
+			}
+		})
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"record": record,
+			"job":    job,
+		})
+	}
+}
+
+// requestApproval stores a purge as pending approval in the history
+func requestApproval(ctx v1alpha1.Context, store storage.Store, user string, req v1alpha1.PurgeRequest,
+	rule string, requestedAt time.Time) (record v1alpha1.PurgeRecord, err error) {
+
+	id, err := storage.NewID(requestedAt)
+	if err != nil {
+		return record, err
+	}
+
+	record = approval.NewRecord(ctx, id, user, req, rule, requestedAt)
+	return record, store.Save(record)
+}
+
+// ApprovalsPage renders the purges pending approval, which the user can approve or reject
+func ApprovalsPage(ctx v1alpha1.Context, store storage.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		data := fiber.Map{
+			"Identity": pageIdentity(c),
+			"Enabled":  ctx.Config().Approvals.Enabled && store != nil,
+		}
+
+		if store == nil {
+			return c.Render("approvals", data)
+		}
+
+		records, total, err := store.List(v1alpha1.PurgeFilter{
+			Status: v1alpha1.PurgeStatusPending,
+			Limit:  storage.MaxLimit,
+		})
+		if err != nil {
+			ctx.Logger.Errorf("Failed to list pending purges: %v\n", err)
+			data["Errors"] = []v1alpha1.ValidationError{{Field: "approvals", Message: "failed to list pending purges"}}
+			return c.Status(fiber.StatusInternalServerError).Render("approvals", data)
+		}
+
+		data["Records"] = records
+		data["Total"] = total
+		data["Now"] = time.Now()
+
+		return c.Render("approvals", data)
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/akamai"
+	"akapurgo/internal/auth"
+	"akapurgo/internal/config"
+	"akapurgo/internal/jobs"
+	"akapurgo/internal/purge"
+	"akapurgo/internal/ratelimit"
+	"akapurgo/internal/secrets"
+	"akapurgo/internal/storage"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+func TestApprovalSelfReview(t *testing.T) {
+	ccu := newFakeCCU(t)
+
+	configContent := &v1alpha1.ConfigSpec{}
+	configContent.Akamai.Host = ccu.server.URL
+	configContent.Akamai.ClientSecret = "secret"
+	configContent.Akamai.ClientToken = "client-token"
+	configContent.Akamai.AccessToken = "access-token"
+	configContent.History.Enabled = true
+	configContent.History.Path = filepath.Join(t.TempDir(), "history.db")
+	configContent.Approvals.Enabled = true
+	configContent.Approvals.Rules = []v1alpha1.ApprovalRule{{Name: "deletes", ActionTypes: []string{"delete"}}}
+	configContent.Auth.APIKeys.Enabled = true
+	configContent.Auth.APIKeys.Keys = []v1alpha1.APIKey{
+		{Name: "alice", Hash: auth.HashAPIKey("alice-key"), Scopes: []string{auth.ScopeAll}},
+		{Name: "bob", Hash: auth.HashAPIKey("bob-key"), Scopes: []string{auth.ScopeAll}},
+	}
+	configContent.Logs.JwtUser.Enabled = true
+	configContent.Logs.JwtUser.Header = "X-Jwt"
+	configContent.Logs.JwtUser.JwtField = "sub"
+	config.SetDefaults(configContent)
+	ctx := v1alpha1.NewContext(configContent, zap.NewNop().Sugar())
+
+	credentials, err := akamai.NewCredentials(configContent, secrets.NewResolver(configContent.Secrets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.NewStore(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	authenticator, err := auth.NewAuthenticator(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The routes do not require authentication, the handlers must not trust unverified users on their own
+	purger := purge.NewPurger(ctx, ratelimit.NewLimiter(ctx), credentials)
+	jobStore := jobs.NewStore(time.Hour)
+	app := fiber.New()
+	app.Use(authenticator.Middleware())
+	app.Post("/api/v1/purge", PurgeHandler(ctx, purger, jobStore, store))
+	app.Post("/api/v1/purges/:id/approve", ReviewHandler(ctx, purger, jobStore, store, true))
+
+	send := func(path string, headers map[string]string, body []byte) (*http.Response, map[string]any) {
+		t.Helper()
+
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		response, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		var payload map[string]any
+		json.NewDecoder(response.Body).Decode(&payload)
+		return response, payload
+	}
+
+	purgeBody, _ := json.Marshal(v1alpha1.PurgeRequest{
+		PurgeType:   "urls",
+		ActionType:  "delete",
+		Environment: "staging",
+		Paths:       []string{"https://www.example.com/"},
+	})
+	forgedJWT := "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + ".forged"
+
+	// Anonymous and forged requesters can not leave a purge pending, to approve it later as themselves
+	for name, headers := range map[string]map[string]string{
+		"anonymous":  nil,
+		"forged JWT": {"X-Jwt": forgedJWT},
+	} {
+		if response, _ := send("/api/v1/purge", headers, purgeBody); response.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("%s requester: expected status 401, got %d", name, response.StatusCode)
+		}
+	}
+	if _, total, _ := store.List(v1alpha1.PurgeFilter{Limit: 10}); total != 0 {
+		t.Fatalf("expected no pending purge, got %d", total)
+	}
+
+	// Authenticated requesters can not approve their own purges, a second user can
+	response, record := send("/api/v1/purge", map[string]string{auth.APIKeyHeader: "alice-key"}, purgeBody)
+	if response.StatusCode != fiber.StatusAccepted || record["user"] != "alice" {
+		t.Fatalf("expected a purge pending for alice, got %d: %v", response.StatusCode, record)
+	}
+	approvePath := "/api/v1/purges/" + record["id"].(string) + "/approve"
+
+	for name, headers := range map[string]map[string]string{
+		"requester":           {auth.APIKeyHeader: "alice-key"},
+		"requester as forged": {auth.APIKeyHeader: "alice-key", "X-Jwt": forgedJWT},
+		"anonymous":           nil,
+	} {
+		if response, _ := send(approvePath, headers, nil); response.StatusCode != fiber.StatusForbidden {
+			t.Errorf("%s reviewer: expected status 403, got %d", name, response.StatusCode)
+		}
+	}
+
+	response, review := send(approvePath, map[string]string{auth.APIKeyHeader: "bob-key"}, nil)
+	if response.StatusCode != fiber.StatusAccepted {
+		t.Fatalf("expected the purge approved by bob, got %d: %v", response.StatusCode, review)
+	}
+
+	// Wait for the approved purge, so that it does not outlive the store
+	jobID := review["job"].(map[string]any)["id"].(string)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if job, _ := jobStore.Get(jobID); job.Stage == v1alpha1.JobStageDone || job.Stage == v1alpha1.JobStageFailed {
+			return
+		}
+	}
+	t.Error("approved purge did not finish")
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/storage"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	PurgeStatuses = []string{v1alpha1.PurgeStatusSucceeded, v1alpha1.PurgeStatusPartial, v1alpha1.PurgeStatusFailed,
+		v1alpha1.PurgeStatusPending, v1alpha1.PurgeStatusApproved, v1alpha1.PurgeStatusRejected, v1alpha1.PurgeStatusExpired}
+)
+
+// HistoryHandler lists the purges stored in the history.
+// Records can be filtered by user, date range (from, to), path substring and status, and paginated with offset and limit
+func HistoryHandler(ctx v1alpha1.Context, store storage.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		if store == nil {
+			return c.Status(fiber.StatusNotFound).JSON(map[string]string{
+				"error": "Purge history is disabled",
+			})
+		}
+
+		filter, validationErrors := parsePurgeFilter(c)
+		if len(validationErrors) > 0 {
+			ctx.Logger.Errorf("Invalid history filter: %d wrong fields", len(validationErrors))
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":  "Invalid history filter",
+				"errors": validationErrors,
+			})
+		}
+
+		records, total, err := store.List(filter)
+		if err != nil {
+			ctx.Logger.Errorf("Failed to list purge history: %v\n", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(map[string]string{
+				"error": "Failed to list purge history",
+			})
+		}
+
+		if records == nil {
+			records = []v1alpha1.PurgeRecord{}
+		}
+
+		return c.JSON(v1alpha1.PurgeList{
+			Total:  total,
+			Offset: filter.Offset,
+			Limit:  filter.Limit,
+			Items:  records,
+		})
+	}
+}
+
+// parsePurgeFilter reads the history filter from the query parameters.
+// Dates are accepted as RFC 3339 timestamps or as plain dates (2006-01-02)
+func parsePurgeFilter(c *fiber.Ctx) (filter v1alpha1.PurgeFilter, errs []v1alpha1.ValidationError) {
+	filter = v1alpha1.PurgeFilter{
+		User:   c.Query("user"),
+		Path:   c.Query("path"),
+		Status: c.Query("status"),
+		Offset: c.QueryInt("offset", 0),
+		Limit:  c.QueryInt("limit", storage.DefaultLimit),
+	}
+
+	if filter.Status != "" && !slices.Contains(PurgeStatuses, filter.Status) {
+		errs = append(errs, v1alpha1.ValidationError{
+			Field: "status", Value: filter.Status, Message: "must be one of: " + strings.Join(PurgeStatuses, ", "),
+		})
+	}
+
+	if filter.Offset < 0 {
+		errs = append(errs, v1alpha1.ValidationError{
+			Field: "offset", Value: c.Query("offset"), Message: "must be a positive number",
+		})
+	}
+
+	if filter.Limit <= 0 || filter.Limit > storage.MaxLimit {
+		errs = append(errs, v1alpha1.ValidationError{
+			Field: "limit", Value: c.Query("limit"), Message: "must be a number between 1 and 500",
+		})
+	}
+
+	var err error
+	if filter.From, err = parseDate(c.Query("from"), false); err != nil {
+		errs = append(errs, v1alpha1.ValidationError{
+			Field: "from", Value: c.Query("from"), Message: err.Error(),
+		})
+	}
+
+	if filter.To, err = parseDate(c.Query("to"), true); err != nil {
+		errs = append(errs, v1alpha1.ValidationError{
+			Field: "to", Value: c.Query("to"), Message: err.Error(),
+		})
+	}
+
+	return filter, errs
+}
+
+// parseDate parses a date from the query. Plain dates used as the end of a range include the whole day
+func parseDate(value string, endOfDay bool) (date time.Time, err error) {
+	if value == "" {
+		return date, nil
+	}
+
+	if date, err = time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+
+	if date, err = time.Parse(time.DateOnly, value); err == nil {
+		if endOfDay {
+			date = date.Add(24*time.Hour - time.Nanosecond)
+		}
+		return date, nil
+	}
+
+	return date, errors.New("must be a RFC 3339 timestamp or a date (YYYY-MM-DD)")
+}
+
+// recordPurge stores the result of a purge in the history, when it is enabled.
+// The record is dated and ordered by the time the purge was requested
+func recordPurge(ctx v1alpha1.Context, store storage.Store, user string, req v1alpha1.PurgeRequest,
+	requestedAt time.Time, response v1alpha1.PurgeResponse, purgeErr error) {
+
+	if store == nil {
+		return
+	}
+
+	id, err := storage.NewID(requestedAt)
+	if err != nil {
+		ctx.Logger.Errorf("Failed to create history record ID: %v\n", err)
+		return
+	}
+
+	record := v1alpha1.PurgeRecord{
+		ID:          id,
+		User:        user,
+		CreatedAt:   requestedAt,
+		PurgeType:   req.PurgeType,
+		ActionType:  req.ActionType,
+		Environment: req.Environment,
+		Account:     req.Account,
+		Paths:       req.Paths,
+	}
+	setPurgeResult(&record, response, purgeErr)
+
+	if err := store.Save(record); err != nil {
+		ctx.Logger.Errorf("Failed to store purge in history: %v\n", err)
+	}
+}
+
+// setPurgeResult sets the status, detail, result and purge IDs of a record from the outcome of the purge
+func setPurgeResult(record *v1alpha1.PurgeRecord, response v1alpha1.PurgeResponse, purgeErr error) {
+	record.PurgeIDs = []string{}
+
+	switch {
+	case purgeErr != nil:
+		record.Status = v1alpha1.PurgeStatusFailed
+		record.Detail = purgeErr.Error()
+	default:
+		record.Result = &response
+		record.Account = response.Account
+		record.Detail = response.Detail
+		record.Status = v1alpha1.PurgeStatusFailed
+		if response.HTTPStatus == fiber.StatusMultiStatus {
+			record.Status = v1alpha1.PurgeStatusPartial
+		} else if is2xx(response.HTTPStatus) {
+			record.Status = v1alpha1.PurgeStatusSucceeded
+		}
+
+		for _, batch := range response.Batches {
+			if batch.PurgeID != "" {
+				record.PurgeIDs = append(record.PurgeIDs, batch.PurgeID)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/akamai"
+	"akapurgo/internal/auth"
+	"akapurgo/internal/commons"
+	"akapurgo/internal/storage"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// IndexPage renders the purge form. When the query parameter rerun holds the ID of a purge from the history,
+// the form is pre-filled with it
+func IndexPage(ctx v1alpha1.Context, store storage.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		data := fiber.Map{
+			"Identity": pageIdentity(c),
+		}
+
+		// The account can be chosen when several are configured, otherwise it is routed by hostname
+		if configured := ctx.Config().Akamai.Accounts; len(configured) > 0 {
+			accounts := []string{akamai.DefaultAccount}
+			for _, account := range configured {
+				accounts = append(accounts, account.Name)
+			}
+			data["Accounts"] = accounts
+		}
+
+		// Re-runs show a purge of the history, so they require the same scope as the history
+		identity, _ := commons.GetIdentity(c)
+		if id := c.Query("rerun"); id != "" && store != nil && auth.HasScope(identity, auth.ScopeHistoryRead) {
+			record, err := store.Get(id)
+			if err != nil {
+				ctx.Logger.Errorf("Failed to get purge '%s' from history: %v\n", id, err)
+			} else {
+				data["Rerun"] = record
+				data["RerunPaths"] = strings.Join(record.Paths, "\n")
+			}
+		}
+
+		return c.Render("index", data)
+	}
+}
+
+// HistoryPage renders the list of recent purges stored in the history, filtered by the same
+// query parameters accepted by the history API
+func HistoryPage(ctx v1alpha1.Context, store storage.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		data := fiber.Map{
+			"Identity": pageIdentity(c),
+			"Enabled":  store != nil,
+			"Statuses": PurgeStatuses,
+			"Query": fiber.Map{
+				"User":   c.Query("user"),
+				"Path":   c.Query("path"),
+				"Status": c.Query("status"),
+				"From":   c.Query("from"),
+				"To":     c.Query("to"),
+			},
+		}
+
+		if store == nil {
+			return c.Render("history", data)
+		}
+
+		filter, validationErrors := parsePurgeFilter(c)
+		if len(validationErrors) > 0 {
+			data["Errors"] = validationErrors
+			return c.Status(fiber.StatusBadRequest).Render("history", data)
+		}
+
+		records, total, err := store.List(filter)
+		if err != nil {
+			ctx.Logger.Errorf("Failed to list purge history: %v\n", err)
+			data["Errors"] = []v1alpha1.ValidationError{{Field: "history", Message: "failed to list purge history"}}
+			return c.Status(fiber.StatusInternalServerError).Render("history", data)
+		}
+
+		data["Records"] = records
+		data["Total"] = total
+		data["From"] = min(filter.Offset+1, total)
+		data["To"] = filter.Offset + len(records)
+
+		// Links to the previous and next pages keep the current filters
+		query := c.Context().QueryArgs()
+		if filter.Offset > 0 {
+			query.SetUint("offset", max(filter.Offset-filter.Limit, 0))
+			data["PrevPage"] = "?" + query.String()
+		}
+		if filter.Offset+len(records) < total {
+			query.SetUint("offset", filter.Offset+filter.Limit)
+			data["NextPage"] = "?" + query.String()
+		}
+
+		return c.Render("history", data)
+	}
+}
+
+// pageIdentity returns the identity of the user logged in the web UI, if any
+func pageIdentity(c *fiber.Ctx) *v1alpha1.Identity {
+	if identity, ok := commons.GetIdentity(c); ok && identity.Method == v1alpha1.AuthMethodOIDC {
+		return &identity
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package approval
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/policy"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var (
+	ErrNotPending = errors.New("purge is not pending approval")
+	ErrForbidden  = errors.New("not allowed to review this purge")
+)
+
+// Required returns the name of the first approval rule matching the request, when the request needs approval
+func Required(ctx v1alpha1.Context, req v1alpha1.PurgeRequest) (rule string, required bool) {
+	if !ctx.Config().Approvals.Enabled {
+		return "", false
+	}
+
+	for _, approvalRule := range ctx.Config().Approvals.Rules {
+		if matches(approvalRule, req) {
+			return approvalRule.Name, true
+		}
+	}
+
+	return "", false
+}
+
+// matches returns whether the request matches all the conditions set in the rule
+func matches(rule v1alpha1.ApprovalRule, req v1alpha1.PurgeRequest) bool {
+	if len(rule.ActionTypes) > 0 && !slices.Contains(rule.ActionTypes, req.ActionType) {
+		return false
+	}
+
+	if len(rule.Environments) > 0 && !slices.Contains(rule.Environments, req.Environment) {
+		return false
+	}
+
+	if rule.MaxPaths > 0 && len(req.Paths) <= rule.MaxPaths {
+		return false
+	}
+
+	return true
+}
+
+// NewRecord returns the history record of a purge waiting for approval
+func NewRecord(ctx v1alpha1.Context, id string, user string, req v1alpha1.PurgeRequest, rule string, now time.Time) v1alpha1.PurgeRecord {
+	return v1alpha1.PurgeRecord{
+		ID:               id,
+		User:             user,
+		CreatedAt:        now,
+		PurgeType:        req.PurgeType,
+		ActionType:       req.ActionType,
+		Environment:      req.Environment,
+		Account:          req.Account,
+		Paths:            req.Paths,
+		PurgeIDs:         []string{},
+		Status:           v1alpha1.PurgeStatusPending,
+		Detail:           fmt.Sprintf("waiting for approval, required by rule '%s'", rule),
+		PostPurgeRequest: req.PostPurgeRequest,
+		Approval: &v1alpha1.Approval{
+			Rule:      rule,
+			ExpiresAt: now.Add(ctx.Config().Approvals.Expiration),
+		},
+	}
+}
+
+// Request returns the purge request of a record, to run it once approved
+func Request(record v1alpha1.PurgeRecord) v1alpha1.PurgeRequest {
+	return v1alpha1.PurgeRequest{
+		PurgeType:        record.PurgeType,
+		ActionType:       record.ActionType,
+		Environment:      record.Environment,
+		PostPurgeRequest: record.PostPurgeRequest,
+		Account:          record.Account,
+		Paths:            record.Paths,
+	}
+}
+
+// Review approves or rejects a pending record in place. The reviewer must be a configured approver other
+// than the requester, allowed by the policies to run the purge. Expired records are marked as such and
+// ErrNotPending is returned
+func Review(ctx v1alpha1.Context, identity v1alpha1.Identity, record *v1alpha1.PurgeRecord, approve bool,
+	comment string, now time.Time) error {
+
+	if record.Status != v1alpha1.PurgeStatusPending || record.Approval == nil {
+		return fmt.Errorf("%w: status is '%s'", ErrNotPending, record.Status)
+	}
+
+	if err := canReview(ctx, identity, *record); err != nil {
+		return err
+	}
+
+	record.Approval.Reviewer = identity.User
+	record.Approval.Comment = comment
+	record.Approval.ReviewedAt = &now
+
+	switch {
+	case now.After(record.Approval.ExpiresAt):
+		record.Status = v1alpha1.PurgeStatusExpired
+		record.Detail = "approval expired"
+	case approve:
+		record.Status = v1alpha1.PurgeStatusApproved
+		record.Detail = fmt.Sprintf("approved by '%s'", identity.User)
+	default:
+		record.Status = v1alpha1.PurgeStatusRejected
+		record.Detail = fmt.Sprintf("rejected by '%s'", identity.User)
+	}
+
+	return nil
+}
+
+// canReview checks whether the identity may review the purge of the record
+func canReview(ctx v1alpha1.Context, identity v1alpha1.Identity, record v1alpha1.PurgeRecord) error {
+	if identity.User == "" {
+		return fmt.Errorf("%w: authentication required", ErrForbidden)
+	}
+
+	if identity.User == record.User {
+		return fmt.Errorf("%w: purges must be reviewed by a second user", ErrForbidden)
+	}
+
+	if !isApprover(ctx, identity) {
+		return fmt.Errorf("%w: user '%s' is not an approver", ErrForbidden, identity.User)
+	}
+
+	if decision := policy.Evaluate(ctx, identity, Request(record)); !decision.Allowed {
+		return fmt.Errorf("%w: %s", ErrForbidden, decision.Reason)
+	}
+
+	return nil
+}
+
+// isApprover returns whether the identity is one of the configured approvers. When none is configured,
+// any authenticated user is
+func isApprover(ctx v1alpha1.Context, identity v1alpha1.Identity) bool {
+	approvers := ctx.Config().Approvals.Approvers
+	if len(approvers.Users) == 0 && len(approvers.Groups) == 0 {
+		return true
+	}
+
+	if slices.Contains(approvers.Users, policy.Wildcard) || slices.Contains(approvers.Users, identity.User) {
+		return true
+	}
+
+	for _, group := range identity.Groups {
+		if slices.Contains(approvers.Groups, group) || slices.Contains(approvers.Groups, policy.Wildcard) {
+			return true
+		}
+	}
+
+	return false
+}
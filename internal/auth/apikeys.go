@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"akapurgo/api/v1alpha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// apiKeyHashPrefix is the prefix of the hashes of the API keys in the configuration
+	apiKeyHashPrefix = "sha256:"
+
+	// ScopeAll grants every scope
+	ScopeAll = "*"
+
+	ScopePurgeAll        = "purge:*"
+	ScopeHistoryRead     = "history:read"
+	ScopeApprovalsReview = "approvals:review"
+)
+
+var (
+	ErrAPIKeyUnknown = errors.New("unknown API key")
+	ErrAPIKeyExpired = errors.New("API key expired")
+)
+
+// APIKeyVerifier checks the static API keys used by pipelines. Only the hashes of the keys are kept.
+// The keys can be replaced while in use, to revoke keys on configuration reloads
+type APIKeyVerifier struct {
+	mutex sync.RWMutex
+	keys  map[string]v1alpha1.APIKey
+}
+
+// NewAPIKeyVerifier loads the API keys from the configuration and from the keys file, when set
+func NewAPIKeyVerifier(config v1alpha1.APIKeysConfig) (*APIKeyVerifier, error) {
+	keys := slices.Clone(config.Keys)
+
+	if config.File != "" {
+		fileBytes, err := os.ReadFile(config.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read API keys file: %v", err)
+		}
+
+		var file struct {
+			Keys []v1alpha1.APIKey `yaml:"keys"`
+		}
+		if err := yaml.Unmarshal(fileBytes, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse API keys file: %v", err)
+		}
+		keys = append(keys, file.Keys...)
+	}
+
+	verifier := &APIKeyVerifier{keys: map[string]v1alpha1.APIKey{}}
+	for _, key := range keys {
+		if key.Name == "" {
+			return nil, errors.New("API keys require a name")
+		}
+
+		hash := strings.ToLower(strings.TrimPrefix(key.Hash, apiKeyHashPrefix))
+		if hashBytes, err := hex.DecodeString(hash); err != nil || len(hashBytes) != sha256.Size {
+			return nil, fmt.Errorf("API key '%s' must have a SHA-256 hash in hex format", key.Name)
+		}
+
+		verifier.keys[hash] = key
+	}
+
+	return verifier, nil
+}
+
+// Replace swaps in the keys of another verifier, built from a reloaded configuration
+func (v *APIKeyVerifier) Replace(other *APIKeyVerifier) {
+	other.mutex.RLock()
+	keys := other.keys
+	other.mutex.RUnlock()
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.keys = keys
+}
+
+// HashAPIKey returns the hash of an API key, in the format expected in the configuration
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return apiKeyHashPrefix + hex.EncodeToString(hash[:])
+}
+
+// Identity checks the API key and returns the identity of its owner, named after the key
+func (v *APIKeyVerifier) Identity(key string) (identity v1alpha1.Identity, err error) {
+	hash := sha256.Sum256([]byte(key))
+
+	v.mutex.RLock()
+	apiKey, ok := v.keys[hex.EncodeToString(hash[:])]
+	v.mutex.RUnlock()
+	if !ok {
+		return identity, ErrAPIKeyUnknown
+	}
+
+	if !apiKey.Expires.IsZero() && time.Now().After(apiKey.Expires) {
+		return identity, fmt.Errorf("%w: '%s'", ErrAPIKeyExpired, apiKey.Name)
+	}
+
+	return v1alpha1.Identity{
+		User:   apiKey.Name,
+		Groups: apiKey.Groups,
+		Scopes: apiKey.Scopes,
+		Method: v1alpha1.AuthMethodAPIKey,
+	}, nil
+}
+
+// HasScope returns whether the identity was granted the given scope. Scopes only restrict API keys
+func HasScope(identity v1alpha1.Identity, scope string) bool {
+	if identity.Method != v1alpha1.AuthMethodAPIKey {
+		return true
+	}
+
+	if slices.Contains(identity.Scopes, ScopeAll) || slices.Contains(identity.Scopes, scope) {
+		return true
+	}
+
+	return strings.HasPrefix(scope, "purge:") && slices.Contains(identity.Scopes, ScopePurgeAll)
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/commons"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// APIKeyHeader is the header carrying the API keys, as an alternative to the Authorization header
+	APIKeyHeader = "X-API-Key"
+)
+
+var (
+	ErrNoCredentials = errors.New("no credentials found in request")
+)
+
+// Authenticator identifies the callers of the API from the credentials sent in their requests
+type Authenticator struct {
+	ctx     v1alpha1.Context
+	jwt     *JWTVerifier
+	apiKeys *APIKeyVerifier
+	oidc    *OIDCProvider
+}
+
+// NewAuthenticator creates an authenticator for the methods enabled in the configuration
+func NewAuthenticator(ctx v1alpha1.Context) (authenticator *Authenticator, err error) {
+	authenticator = &Authenticator{ctx: ctx}
+
+	if ctx.Config().Auth.JWT.Enabled {
+		authenticator.jwt, err = NewJWTVerifier(ctx.Config().Auth.JWT)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ctx.Config().Auth.APIKeys.Enabled {
+		authenticator.apiKeys, err = NewAPIKeyVerifier(ctx.Config().Auth.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if ctx.Config().Auth.OIDC.Enabled {
+		authenticator.oidc, err = NewOIDCProvider(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return authenticator, nil
+}
+
+// OIDC returns the OIDC provider of the web UI, or nil when the OIDC login is disabled
+func (a *Authenticator) OIDC() *OIDCProvider {
+	return a.oidc
+}
+
+// ReloadAPIKeys replaces the API keys with those of a reloaded configuration, so that revoked keys are rejected
+// right away. Enabling or disabling the API keys is only applied on restart
+func (a *Authenticator) ReloadAPIKeys(config v1alpha1.APIKeysConfig) error {
+	if a.apiKeys == nil {
+		return nil
+	}
+
+	apiKeys, err := NewAPIKeyVerifier(config)
+	if err != nil {
+		return err
+	}
+
+	a.apiKeys.Replace(apiKeys)
+	return nil
+}
+
+// Authenticate returns the identity of the caller. ErrNoCredentials is returned when
+// the request carries no credentials for any of the enabled methods
+// API keys are read from the X-API-Key header, or from the Authorization header when they are sent as bearer tokens
+func (a *Authenticator) Authenticate(c *fiber.Ctx) (identity v1alpha1.Identity, err error) {
+	if a.apiKeys != nil {
+		if key := c.Get(APIKeyHeader); key != "" {
+			return a.apiKeys.Identity(key)
+		}
+
+		// Bearer tokens that are not API keys may still be JWTs
+		if key := bearerToken(c.Get(fiber.HeaderAuthorization)); key != "" {
+			identity, err = a.apiKeys.Identity(key)
+			if err == nil || !errors.Is(err, ErrAPIKeyUnknown) || a.jwt == nil {
+				return identity, err
+			}
+		}
+	}
+
+	if a.jwt != nil {
+		config := a.ctx.Config().Auth.JWT
+
+		// The cookie of the web UI comes first, then the header of the API clients
+		var token string
+		if config.Cookie != "" {
+			token = c.Cookies(config.Cookie)
+		}
+		if token == "" {
+			token = bearerToken(c.Get(config.Header))
+		}
+
+		if token != "" {
+			return a.jwt.Identity(token)
+		}
+	}
+
+	// Users of the web UI are identified by their session cookie
+	if a.oidc != nil {
+		return a.oidc.Identity(c)
+	}
+
+	return identity, ErrNoCredentials
+}
+
+// Middleware authenticates every request, storing the identity of the caller in the request locals.
+// Requests without valid credentials go on unauthenticated, RequireAuthentication rejects them where needed
+func (a *Authenticator) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		identity, err := a.Authenticate(c)
+		if err == nil {
+			c.Locals(commons.IdentityLocalsKey, identity)
+			return c.Next()
+		}
+
+		if !errors.Is(err, ErrNoCredentials) {
+			a.ctx.Logger.Warnf("Failed to authenticate request: %v\n", err)
+			c.Locals(commons.AuthErrorLocalsKey, err.Error())
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireAuthentication rejects the requests without a valid identity when authentication is required
+func RequireAuthentication(ctx v1alpha1.Context) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !ctx.Config().Auth.Required {
+			return c.Next()
+		}
+
+		if _, ok := commons.GetIdentity(c); ok {
+			return c.Next()
+		}
+
+		message := "Authentication required"
+		if authErr, ok := c.Locals(commons.AuthErrorLocalsKey).(string); ok {
+			message = "Invalid credentials: " + authErr
+		}
+
+		return c.Status(fiber.StatusUnauthorized).JSON(map[string]string{
+			"error": message,
+		})
+	}
+}
+
+// RequireScope rejects the requests authenticated with API keys lacking the given scope
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		identity, _ := commons.GetIdentity(c)
+		if !HasScope(identity, scope) {
+			return c.Status(fiber.StatusForbidden).JSON(map[string]string{
+				"error": fmt.Sprintf("API key '%s' lacks the scope '%s'", identity.User, scope),
+			})
+		}
+
+		return c.Next()
+	}
+}
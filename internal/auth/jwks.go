@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// jwksMinRefreshInterval avoids hammering the JWKS endpoint with tokens signed by unknown keys
+	jwksMinRefreshInterval = 1 * time.Minute
+)
+
+var (
+	ErrKeyNotFound = errors.New("signing key not found")
+
+	jwksClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// jsonWebKey is a single key of a JWKS document (RFC 7517)
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// KeySet holds the public keys used to verify the tokens, indexed by key ID.
+// Keys loaded from a JWKS URL are refreshed when they get older than the refresh interval,
+// or when a token is signed by an unknown key
+type KeySet struct {
+	mutex           sync.RWMutex
+	keys            map[string]crypto.PublicKey
+	url             string
+	refreshInterval time.Duration
+	lastRefresh     time.Time
+}
+
+// NewKeySetFromPEM loads a single public key, or certificate, from a PEM file.
+// The key is used for every token, whatever its key ID
+func NewKeySetFromPEM(path string) (*KeySet, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key file: %v", err)
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", path)
+	}
+
+	var key crypto.PublicKey
+	switch block.Type {
+	case "CERTIFICATE":
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %v", err)
+		}
+		key = certificate.PublicKey
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+
+	return &KeySet{keys: map[string]crypto.PublicKey{"": key}}, nil
+}
+
+// NewKeySetFromJWKSFile loads the keys from a JWKS file
+func NewKeySetFromJWKSFile(path string) (*KeySet, error) {
+	jwksBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %v", err)
+	}
+
+	keys, err := parseJWKS(jwksBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeySet{keys: keys}, nil
+}
+
+// NewKeySetFromJWKSURL loads the keys from a JWKS URL, refreshing them periodically
+func NewKeySetFromJWKSURL(url string, refreshInterval time.Duration) (*KeySet, error) {
+	keySet := &KeySet{
+		url:             url,
+		refreshInterval: refreshInterval,
+	}
+
+	if err := keySet.refresh(); err != nil {
+		return nil, err
+	}
+
+	return keySet, nil
+}
+
+// Key returns the public key with the given key ID. Key sets with a single key
+// loaded from a PEM file return it for any key ID
+func (k *KeySet) Key(kid string) (crypto.PublicKey, error) {
+	if k.url != "" {
+		k.mutex.RLock()
+		expired := time.Since(k.lastRefresh) > k.refreshInterval
+		_, found := k.keys[kid]
+		canRefresh := time.Since(k.lastRefresh) > jwksMinRefreshInterval
+		k.mutex.RUnlock()
+
+		if expired || (!found && canRefresh) {
+			// Keep the known keys when the refresh fails, the endpoint may be temporary down
+			_ = k.refresh()
+		}
+	}
+
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+
+	if key, ok := k.keys[""]; ok && len(k.keys) == 1 {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: '%s'", ErrKeyNotFound, kid)
+}
+
+// refresh downloads the keys from the JWKS URL
+func (k *KeySet) refresh() error {
+	k.mutex.Lock()
+	k.lastRefresh = time.Now()
+	k.mutex.Unlock()
+
+	response, err := jwksClient.Get(k.url)
+	if err != nil {
+		return fmt.Errorf("failed to get JWKS from %s: %v", k.url, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get JWKS from %s: status code %d", k.url, response.StatusCode)
+	}
+
+	jwksBytes, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS from %s: %v", k.url, err)
+	}
+
+	keys, err := parseJWKS(jwksBytes)
+	if err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	k.keys = keys
+	k.mutex.Unlock()
+
+	return nil
+}
+
+// parseJWKS parses the public keys of a JWKS document. Keys not meant for signatures are ignored
+func parseJWKS(jwksBytes []byte) (keys map[string]crypto.PublicKey, err error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(jwksBytes, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %v", err)
+	}
+
+	keys = map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := parseJWK(jwk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key '%s': %v", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys found in JWKS")
+	}
+
+	return keys, nil
+}
+
+// parseJWK converts a JSON web key into a public key. RSA, EC and Ed25519 keys are supported
+func parseJWK(jwk jsonWebKey) (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		curves := map[string]elliptic.Curve{
+			"P-256": elliptic.P256(),
+			"P-384": elliptic.P384(),
+			"P-521": elliptic.P521(),
+		}
+		curve, ok := curves[jwk.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve '%s'", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type '%s'", jwk.Kty)
+}
+
+// decodeBigInt decodes a base64url encoded big endian number
+func decodeBigInt(value string) (*big.Int, error) {
+	valueBytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64url number: %v", err)
+	}
+
+	return new(big.Int).SetBytes(valueBytes), nil
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"akapurgo/api/v1alpha1"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// validMethods are the signing methods accepted for the tokens. Symmetric methods are
+	// not accepted, as the tokens are verified with public keys
+	validMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// JWTVerifier verifies the signature and the claims of the JWTs sent to the API
+type JWTVerifier struct {
+	keySet      *KeySet
+	parser      *jwt.Parser
+	userClaim   string
+	groupsClaim string
+}
+
+// NewJWTVerifier creates a verifier for the keys and claims configured in the given JWT configuration
+func NewJWTVerifier(config v1alpha1.JWTConfig) (*JWTVerifier, error) {
+	var keySet *KeySet
+	var err error
+
+	switch {
+	case config.PublicKeyFile != "":
+		keySet, err = NewKeySetFromPEM(config.PublicKeyFile)
+	case config.JWKSFile != "":
+		keySet, err = NewKeySetFromJWKSFile(config.JWKSFile)
+	case config.JWKSURL != "":
+		keySet, err = NewKeySetFromJWKSURL(config.JWKSURL, config.JWKSRefreshInterval)
+	default:
+		err = errors.New("one of public_key_file, jwks_file or jwks_url is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(validMethods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(config.Leeway),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+
+	return &JWTVerifier{
+		keySet:      keySet,
+		parser:      jwt.NewParser(options...),
+		userClaim:   config.UserClaim,
+		groupsClaim: config.GroupsClaim,
+	}, nil
+}
+
+// Verify checks the signature of the token along with its exp, nbf, iss and aud claims,
+// and returns the claims of the token
+func (v *JWTVerifier) Verify(token string) (claims jwt.MapClaims, err error) {
+	claims = jwt.MapClaims{}
+
+	_, err = v.parser.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return v.keySet.Key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// Identity verifies the token and returns the identity of its owner
+func (v *JWTVerifier) Identity(token string) (identity v1alpha1.Identity, err error) {
+	claims, err := v.Verify(token)
+	if err != nil {
+		return identity, err
+	}
+
+	return v.identity(claims, v1alpha1.AuthMethodJWT)
+}
+
+// identity returns the identity of the owner of verified claims
+func (v *JWTVerifier) identity(claims jwt.MapClaims, method string) (identity v1alpha1.Identity, err error) {
+	user, _ := claims[v.userClaim].(string)
+	if user == "" {
+		return identity, fmt.Errorf("claim '%s' not found in token", v.userClaim)
+	}
+
+	return v1alpha1.Identity{
+		User:   user,
+		Groups: stringsClaim(claims, v.groupsClaim),
+		Method: method,
+	}, nil
+}
+
+// stringsClaim returns the values of a claim that can be either a list of strings or a single string
+func stringsClaim(claims jwt.MapClaims, name string) (values []string) {
+	switch claim := claims[name].(type) {
+	case string:
+		values = append(values, claim)
+	case []interface{}:
+		for _, item := range claim {
+			if value, ok := item.(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+
+	return values
+}
+
+// bearerToken returns the token of an Authorization header value, removing the Bearer prefix when present
+func bearerToken(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+		return strings.TrimSpace(value[7:])
+	}
+
+	return value
+}
@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"akapurgo/api/v1alpha1"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// oidcLoginPath and oidcLogoutPath are the routes starting and ending the sessions of the web UI
+	oidcLoginPath  = "/auth/login"
+	oidcLogoutPath = "/auth/logout"
+
+	// oidcStateCookie keeps the state of the login while the user is at the provider
+	oidcStateCookie = "akapurgo_oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+
+	// oidcJWKSRefreshInterval is the refresh interval of the keys of the provider
+	oidcJWKSRefreshInterval = 1 * time.Hour
+)
+
+var (
+	oidcClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// oidcDiscovery holds the fields used from the discovery document of the provider
+// Ref: https://openid.net/specs/openid-connect-discovery-1_0.html
+type oidcDiscovery struct {
+	Issuer                   string   `json:"issuer"`
+	AuthorizationEndpoint    string   `json:"authorization_endpoint"`
+	TokenEndpoint            string   `json:"token_endpoint"`
+	JWKSURI                  string   `json:"jwks_uri"`
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// OIDCProvider logs the users of the web UI in with the OpenID Connect authorization code flow.
+// The ID tokens are verified like the JWTs sent to the API, then the identity is kept in a signed session cookie
+type OIDCProvider struct {
+	ctx          v1alpha1.Context
+	config       v1alpha1.OIDCConfig
+	discovery    oidcDiscovery
+	verifier     *JWTVerifier
+	signer       *cookieSigner
+	callbackPath string
+	secure       bool
+}
+
+// NewOIDCProvider discovers the endpoints and keys of the provider configured in the context
+func NewOIDCProvider(ctx v1alpha1.Context) (*OIDCProvider, error) {
+	config := ctx.Config().Auth.OIDC
+
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("issuer, client_id and redirect_url are required for OIDC")
+	}
+
+	redirectURL, err := url.Parse(config.RedirectURL)
+	if err != nil || redirectURL.Path == "" {
+		return nil, fmt.Errorf("invalid OIDC redirect URL '%s'", config.RedirectURL)
+	}
+
+	discovery, err := discover(config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	// ID tokens are JWTs issued for the client, signed with the keys of the provider
+	verifier, err := NewJWTVerifier(v1alpha1.JWTConfig{
+		JWKSURL:             discovery.JWKSURI,
+		JWKSRefreshInterval: oidcJWKSRefreshInterval,
+		Issuer:              discovery.Issuer,
+		Audience:            config.ClientID,
+		UserClaim:           config.UserClaim,
+		GroupsClaim:         config.GroupsClaim,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sessions do not survive restarts without a configured secret
+	secret := []byte(config.SessionSecret)
+	if len(secret) == 0 {
+		ctx.Logger.Warn("No OIDC session secret configured, sessions will be lost on restart")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate session secret: %v", err)
+		}
+	}
+
+	return &OIDCProvider{
+		ctx:          ctx,
+		config:       config,
+		discovery:    discovery,
+		verifier:     verifier,
+		signer:       &cookieSigner{secret: secret},
+		callbackPath: redirectURL.Path,
+		secure:       redirectURL.Scheme == "https",
+	}, nil
+}
+
+// discover gets the discovery document of the issuer
+func discover(issuer string) (discovery oidcDiscovery, err error) {
+	discoveryURL := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+
+	response, err := oidcClient.Get(discoveryURL)
+	if err != nil {
+		return discovery, fmt.Errorf("failed to get OIDC discovery document: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return discovery, fmt.Errorf("failed to get OIDC discovery document: status code %d", response.StatusCode)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&discovery); err != nil {
+		return discovery, fmt.Errorf("failed to decode OIDC discovery document: %v", err)
+	}
+
+	if discovery.Issuer != issuer {
+		return discovery, fmt.Errorf("OIDC issuer mismatch: expected '%s', got '%s'", issuer, discovery.Issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return discovery, errors.New("OIDC discovery document lacks the authorization, token or JWKS endpoints")
+	}
+
+	return discovery, nil
+}
+
+// Register adds the login, callback and logout routes to the app
+func (p *OIDCProvider) Register(app *fiber.App) {
+	app.Get(oidcLoginPath, p.LoginHandler())
+	app.Get(p.callbackPath, p.CallbackHandler())
+	app.Get(oidcLogoutPath, p.LogoutHandler())
+}
+
+// Identity returns the identity of the user logged in with the session cookie of the request
+func (p *OIDCProvider) Identity(c *fiber.Ctx) (identity v1alpha1.Identity, err error) {
+	value := c.Cookies(p.config.CookieName)
+	if value == "" {
+		return identity, ErrNoCredentials
+	}
+
+	var userSession session
+	if err := p.signer.verify(purposeSession, value, &userSession); err != nil {
+		return identity, err
+	}
+
+	if userSession.User == "" {
+		return identity, ErrInvalidSession
+	}
+
+	if expired(userSession.Expires) {
+		return identity, ErrExpiredSession
+	}
+
+	return v1alpha1.Identity{
+		User:   userSession.User,
+		Groups: userSession.Groups,
+		Method: v1alpha1.AuthMethodOIDC,
+	}, nil
+}
+
+// LoginHandler redirects the user to the provider. The query parameter return_to is the page
+// the user goes back to once logged in
+func (p *OIDCProvider) LoginHandler() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		state := loginState{
+			State:    randomString(),
+			Nonce:    randomString(),
+			ReturnTo: localPath(c.Query("return_to")),
+			Expires:  time.Now().Add(oidcStateTTL).Unix(),
+		}
+
+		value, err := p.signer.sign(purposeLoginState, state)
+		if err != nil {
+			p.ctx.Logger.Errorf("Failed to start OIDC login: %v\n", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Failed to start login")
+		}
+		p.setCookie(c, oidcStateCookie, value, oidcStateTTL)
+
+		query := url.Values{
+			"response_type": {"code"},
+			"client_id":     {p.config.ClientID},
+			"redirect_uri":  {p.config.RedirectURL},
+			"scope":         {strings.Join(p.config.Scopes, " ")},
+			"state":         {state.State},
+			"nonce":         {state.Nonce},
+		}
+
+		separator := "?"
+		if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+			separator = "&"
+		}
+
+		return c.Redirect(p.discovery.AuthorizationEndpoint + separator + query.Encode())
+	}
+}
+
+// CallbackHandler exchanges the authorization code for the ID token of the user, and starts the session
+func (p *OIDCProvider) CallbackHandler() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		var state loginState
+		err := p.signer.verify(purposeLoginState, c.Cookies(oidcStateCookie), &state)
+		p.setCookie(c, oidcStateCookie, "", -1)
+
+		switch {
+		case err != nil || expired(state.Expires):
+			p.ctx.Logger.Warn("OIDC callback without a valid login state")
+			return c.Status(fiber.StatusBadRequest).SendString("Login expired, please try again")
+		case c.Query("state") != state.State:
+			p.ctx.Logger.Warn("OIDC callback with a wrong state")
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid login state")
+		case c.Query("error") != "":
+			p.ctx.Logger.Warnf("OIDC login failed: %s: %s", c.Query("error"), c.Query("error_description"))
+			return c.Status(fiber.StatusUnauthorized).SendString("Login failed: " + c.Query("error"))
+		}
+
+		identity, err := p.exchange(c.Query("code"), state.Nonce)
+		if err != nil {
+			p.ctx.Logger.Errorf("Failed to complete OIDC login: %v\n", err)
+			return c.Status(fiber.StatusUnauthorized).SendString("Login failed")
+		}
+
+		value, err := p.signer.sign(purposeSession, session{
+			User:    identity.User,
+			Groups:  identity.Groups,
+			Expires: time.Now().Add(p.config.SessionDuration).Unix(),
+		})
+		if err != nil {
+			p.ctx.Logger.Errorf("Failed to create session: %v\n", err)
+			return c.Status(fiber.StatusInternalServerError).SendString("Login failed")
+		}
+		p.setCookie(c, p.config.CookieName, value, p.config.SessionDuration)
+
+		p.ctx.Logger.Infof("oidc-login,user='%s'", identity.User)
+		return c.Redirect(state.ReturnTo)
+	}
+}
+
+// LogoutHandler ends the session of the user
+func (p *OIDCProvider) LogoutHandler() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		p.setCookie(c, p.config.CookieName, "", -1)
+		return c.Redirect("/")
+	}
+}
+
+// RequireLogin redirects the users without a session to the login
+func (p *OIDCProvider) RequireLogin() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, err := p.Identity(c); err == nil {
+			return c.Next()
+		}
+
+		return c.Redirect(oidcLoginPath + "?return_to=" + url.QueryEscape(c.OriginalURL()))
+	}
+}
+
+// exchange gets the tokens of the authorization code from the token endpoint, and returns the identity
+// of the verified ID token
+func (p *OIDCProvider) exchange(code string, nonce string) (identity v1alpha1.Identity, err error) {
+	if code == "" {
+		return identity, errors.New("no authorization code in callback")
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.config.RedirectURL},
+	}
+
+	// client_secret_basic is the default authentication method of the token endpoint
+	postSecret := len(p.discovery.TokenEndpointAuthMethods) > 0 &&
+		!slices.Contains(p.discovery.TokenEndpointAuthMethods, "client_secret_basic") &&
+		slices.Contains(p.discovery.TokenEndpointAuthMethods, "client_secret_post")
+	if postSecret {
+		form.Set("client_id", p.config.ClientID)
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	request, err := http.NewRequest("POST", p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return identity, fmt.Errorf("failed to create token request: %v", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if !postSecret {
+		request.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	response, err := oidcClient.Do(request)
+	if err != nil {
+		return identity, fmt.Errorf("failed to call token endpoint: %v", err)
+	}
+	defer response.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&tokens); err != nil {
+		return identity, fmt.Errorf("failed to decode token response: status code %d: %v", response.StatusCode, err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return identity, fmt.Errorf("token endpoint returned status code %d: %s: %s",
+			response.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+
+	if tokens.IDToken == "" {
+		return identity, errors.New("no ID token in token response")
+	}
+
+	claims, err := p.verifier.Verify(tokens.IDToken)
+	if err != nil {
+		return identity, fmt.Errorf("invalid ID token: %v", err)
+	}
+
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return identity, errors.New("invalid ID token: nonce mismatch")
+	}
+
+	return p.verifier.identity(claims, v1alpha1.AuthMethodOIDC)
+}
+
+// setCookie sets a cookie only readable by the server. Negative durations delete the cookie
+func (p *OIDCProvider) setCookie(c *fiber.Ctx, name string, value string, duration time.Duration) {
+	cookie := &fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HTTPOnly: true,
+		Secure:   p.secure,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	}
+
+	if duration < 0 {
+		cookie.Expires = time.Unix(0, 0)
+	} else {
+		cookie.Expires = time.Now().Add(duration)
+	}
+
+	c.Cookie(cookie)
+}
+
+// randomString returns a random hex string for the state and nonce of the logins
+func randomString() string {
+	bytes := make([]byte, 16)
+	_, _ = rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// localPath returns the given path when it is local to the app, to avoid redirecting the users elsewhere
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+
+	return path
+}
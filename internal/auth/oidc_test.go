@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"akapurgo/api/v1alpha1"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+const (
+	testClientID    = "akapurgo"
+	testRedirectURL = "http://akapurgo.example.com/auth/callback"
+	testUser        = "alice@example.com"
+)
+
+// mockProvider is an OpenID Connect provider issuing ID tokens for testUser, with the nonce of the last
+// authorization request
+type mockProvider struct {
+	server *httptest.Server
+	key    ed25519.PrivateKey
+
+	mutex sync.Mutex
+	nonce string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &mockProvider{key: privateKey}
+	mux := http.NewServeMux()
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+
+	issuer := provider.server.URL
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": issuer + "/authorize",
+			"token_endpoint":         issuer + "/token",
+			"jwks_uri":               issuer + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"x":   base64.RawURLEncoding.EncodeToString(publicKey),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "valid-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		provider.mutex.Lock()
+		nonce := provider.nonce
+		provider.mutex.Unlock()
+
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+			"iss":   issuer,
+			"aud":   testClientID,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"email": testUser,
+			"nonce": nonce,
+		})
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(privateKey)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+
+	return provider
+}
+
+// newTestOIDCApp returns an app with the OIDC routes, and a protected page answering with the logged in user
+func newTestOIDCApp(t *testing.T, issuer string) (*fiber.App, *OIDCProvider) {
+	t.Helper()
+
+	config := &v1alpha1.ConfigSpec{}
+	config.Auth.OIDC = v1alpha1.OIDCConfig{
+		Enabled:         true,
+		Issuer:          issuer,
+		ClientID:        testClientID,
+		RedirectURL:     testRedirectURL,
+		Scopes:          []string{"openid", "email"},
+		UserClaim:       "email",
+		SessionSecret:   "test-secret",
+		SessionDuration: time.Hour,
+		CookieName:      "akapurgo_session",
+	}
+
+	provider, err := NewOIDCProvider(v1alpha1.NewContext(config, zap.NewNop().Sugar()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	provider.Register(app)
+	app.Get("/protected", provider.RequireLogin(), func(c *fiber.Ctx) error {
+		identity, err := provider.Identity(c)
+		if err != nil {
+			return err
+		}
+		return c.SendString(identity.User)
+	})
+
+	return app, provider
+}
+
+// request sends a GET request to the app with the given cookies, and returns the response
+func request(t *testing.T, app *fiber.App, target string, cookies ...*http.Cookie) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", target, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	response, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response
+}
+
+// cookie returns the cookie of the given name set by the response
+func cookie(t *testing.T, response *http.Response, name string) *http.Cookie {
+	t.Helper()
+
+	for _, c := range response.Cookies() {
+		if c.Name == name && c.Value != "" {
+			return c
+		}
+	}
+
+	t.Fatalf("cookie %s not set", name)
+	return nil
+}
+
+// login runs the authorization code flow and returns the state and session cookies
+func login(t *testing.T, app *fiber.App, mock *mockProvider) (state *http.Cookie, userSession *http.Cookie) {
+	t.Helper()
+
+	response := request(t, app, "/auth/login?return_to=/protected")
+	if response.StatusCode != fiber.StatusFound {
+		t.Fatalf("login: expected status 302, got %d", response.StatusCode)
+	}
+	state = cookie(t, response, oidcStateCookie)
+
+	authorization, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.mutex.Lock()
+	mock.nonce = authorization.Query().Get("nonce")
+	mock.mutex.Unlock()
+
+	callback := "/auth/callback?code=valid-code&state=" + url.QueryEscape(authorization.Query().Get("state"))
+	response = request(t, app, callback, state)
+	if response.StatusCode != fiber.StatusFound || response.Header.Get("Location") != "/protected" {
+		t.Fatalf("callback: expected redirect to /protected, got %d to '%s'",
+			response.StatusCode, response.Header.Get("Location"))
+	}
+
+	return state, cookie(t, response, "akapurgo_session")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	mock := newMockProvider(t)
+	app, _ := newTestOIDCApp(t, mock.server.URL)
+
+	response := request(t, app, "/protected")
+	if response.StatusCode != fiber.StatusFound {
+		t.Fatalf("expected redirect to login without session, got %d", response.StatusCode)
+	}
+
+	_, userSession := login(t, app, mock)
+
+	response = request(t, app, "/protected", userSession)
+	if response.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected status 200 with session, got %d", response.StatusCode)
+	}
+	body := make([]byte, 64)
+	n, _ := response.Body.Read(body)
+	if string(body[:n]) != testUser {
+		t.Errorf("expected user '%s', got '%s'", testUser, body[:n])
+	}
+}
+
+func TestOIDCCallbackRejected(t *testing.T) {
+	mock := newMockProvider(t)
+	app, _ := newTestOIDCApp(t, mock.server.URL)
+
+	response := request(t, app, "/auth/login")
+	state := cookie(t, response, oidcStateCookie)
+	authorization, _ := url.Parse(response.Header.Get("Location"))
+	stateParam := url.QueryEscape(authorization.Query().Get("state"))
+
+	tests := []struct {
+		name    string
+		target  string
+		cookies []*http.Cookie
+		status  int
+	}{
+		{"no state cookie", "/auth/callback?code=valid-code&state=" + stateParam, nil, fiber.StatusBadRequest},
+		{"wrong state", "/auth/callback?code=valid-code&state=other", []*http.Cookie{state}, fiber.StatusBadRequest},
+		{"invalid code", "/auth/callback?code=invalid&state=" + stateParam, []*http.Cookie{state}, fiber.StatusUnauthorized},
+		{"provider error", "/auth/callback?error=access_denied&state=" + stateParam, []*http.Cookie{state},
+			fiber.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := request(t, app, test.target, test.cookies...)
+			if response.StatusCode != test.status {
+				t.Errorf("expected status %d, got %d", test.status, response.StatusCode)
+			}
+		})
+	}
+}
+
+func TestOIDCForgedSession(t *testing.T) {
+	mock := newMockProvider(t)
+	app, provider := newTestOIDCApp(t, mock.server.URL)
+
+	state, userSession := login(t, app, mock)
+
+	emptyUser, err := provider.signer.sign(purposeSession, session{Expires: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner := &cookieSigner{secret: []byte("other-secret")}
+	otherSecret, err := otherSigner.sign(purposeSession, session{User: testUser, Expires: time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"login state cookie", state.Value},
+		{"tampered payload", "x" + userSession.Value},
+		{"empty user", emptyUser},
+		{"other secret", otherSecret},
+		{"no signature", "eyJ1c2VyIjoiYWxpY2UifQ"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := request(t, app, "/protected", &http.Cookie{Name: "akapurgo_session", Value: test.value})
+			if response.StatusCode != fiber.StatusFound {
+				t.Errorf("expected redirect to login, got %d", response.StatusCode)
+			}
+		})
+	}
+
+	// A session cookie can not be used as the state of a login either
+	response := request(t, app, "/auth/callback?code=valid-code&state=",
+		&http.Cookie{Name: oidcStateCookie, Value: userSession.Value})
+	if response.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected status 400 with a session as login state, got %d", response.StatusCode)
+	}
+}
+
+func TestOIDCExpiry(t *testing.T) {
+	mock := newMockProvider(t)
+	app, provider := newTestOIDCApp(t, mock.server.URL)
+
+	expiredSession, err := provider.signer.sign(purposeSession, session{
+		User:    testUser,
+		Expires: time.Now().Add(-time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := request(t, app, "/protected", &http.Cookie{Name: "akapurgo_session", Value: expiredSession})
+	if response.StatusCode != fiber.StatusFound {
+		t.Errorf("expected redirect to login with an expired session, got %d", response.StatusCode)
+	}
+
+	expiredState, err := provider.signer.sign(purposeLoginState, loginState{
+		State:    "state",
+		Nonce:    "nonce",
+		ReturnTo: "/",
+		Expires:  time.Now().Add(-time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response = request(t, app, "/auth/callback?code=valid-code&state=state",
+		&http.Cookie{Name: oidcStateCookie, Value: expiredState})
+	if response.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("expected status 400 with an expired login state, got %d", response.StatusCode)
+	}
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSession = errors.New("invalid session")
+	ErrExpiredSession = errors.New("session expired")
+)
+
+// Purposes of the signed cookies. They are part of the signature, so a cookie signed for a purpose
+// can not be used for another one
+const (
+	purposeSession    = "session"
+	purposeLoginState = "login-state"
+)
+
+// session is the content of the session cookie of the users logged in the web UI
+type session struct {
+	User    string   `json:"user"`
+	Groups  []string `json:"groups,omitempty"`
+	Expires int64    `json:"exp"`
+}
+
+// loginState is the content of the cookie kept during the login, to check the callback of the provider
+type loginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	ReturnTo string `json:"return_to"`
+	Expires  int64  `json:"exp"`
+}
+
+// cookieSigner signs the content of the cookies with HMAC-SHA256, so they can not be forged by the clients
+type cookieSigner struct {
+	secret []byte
+}
+
+// sign encodes the payload as JSON and returns it along with its signature for the purpose: <payload>.<signature>
+func (s *cookieSigner) sign(purpose string, payload interface{}) (string, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cookie: %v", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payloadBytes)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(purpose, encoded)), nil
+}
+
+// verify checks the signature of the value for the purpose and decodes its payload
+func (s *cookieSigner) verify(purpose string, value string, payload interface{}) error {
+	encoded, signature, found := strings.Cut(value, ".")
+	if !found {
+		return ErrInvalidSession
+	}
+
+	signatureBytes, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(signatureBytes, s.mac(purpose, encoded)) {
+		return ErrInvalidSession
+	}
+
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrInvalidSession
+	}
+
+	if err := json.Unmarshal(payloadBytes, payload); err != nil {
+		return ErrInvalidSession
+	}
+
+	return nil
+}
+
+func (s *cookieSigner) mac(purpose string, value string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(purpose + "."))
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// expired returns whether a Unix timestamp is in the past
+func expired(expires int64) bool {
+	return time.Now().Unix() >= expires
+}
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"akapurgo/internal/cmd/purge"
 	"akapurgo/internal/cmd/run"
+	"akapurgo/internal/cmd/validateconfig"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -23,6 +25,8 @@ func NewRootCommand(name string) *cobra.Command {
 
 	c.AddCommand(
 		run.NewCommand(),
+		purge.NewCommand(),
+		validateconfig.NewCommand(),
 	)
 
 	return c
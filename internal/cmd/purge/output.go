@@ -0,0 +1,68 @@
+package purge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// printResult writes the result as a table, or as the JSON returned by the purge API
+func printResult(writer io.Writer, output string, result *Result) error {
+	if output == OutputJSON {
+		var value interface{} = result.Response
+		switch {
+		case result.Plan != nil:
+			value = result.Plan
+		case result.Pending != nil:
+			value = result.Pending
+		}
+
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	}
+
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+
+	switch {
+	case result.Pending != nil:
+		record := result.Pending
+		fmt.Fprintf(table, "ID\tSTATUS\tRULE\tEXPIRES\n")
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", record.ID, record.Status, record.Approval.Rule,
+			record.Approval.ExpiresAt.Format("2006-01-02 15:04:05 MST"))
+		table.Flush()
+		fmt.Fprintf(writer, "\nPurge waiting for approval: %s\n", record.Detail)
+
+	case result.Plan != nil:
+		plan := result.Plan
+		fmt.Fprintf(table, "ACCOUNT\tMETHOD\tURL\tOBJECTS\n")
+		for _, call := range plan.Calls {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%d\n", plan.Account, call.Method, call.URL, call.Objects)
+		}
+		table.Flush()
+
+		fmt.Fprintf(writer, "\nDry run: %d calls to Akamai planned", len(plan.Calls))
+		if plan.PostPurge != nil {
+			fmt.Fprintf(writer, ", then %d warm-up requests after %s", len(plan.PostPurge.Requests), plan.PostPurge.Delay)
+		}
+		if plan.ApprovalRequired != "" {
+			fmt.Fprintf(writer, ", waiting for approval (rule '%s')", plan.ApprovalRequired)
+		}
+		fmt.Fprintln(writer)
+
+	default:
+		response := result.Response
+		fmt.Fprintf(table, "BATCH\tOBJECTS\tSTATUS\tATTEMPTS\tPURGE ID\tESTIMATED\tDETAIL\n")
+		for index, batch := range response.Batches {
+			fmt.Fprintf(table, "%d\t%d\t%d\t%d\t%s\t%ds\t%s\n", index+1, batch.Objects, batch.HTTPStatus,
+				batch.Attempts, batch.PurgeID, batch.EstimatedSeconds, batch.Detail)
+		}
+		table.Flush()
+
+		fmt.Fprintf(writer, "\nAccount %s: %s (status %d, estimated %ds)\n", response.Account, response.Detail,
+			response.HTTPStatus, response.EstimatedSeconds)
+	}
+
+	return nil
+}
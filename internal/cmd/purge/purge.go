@@ -0,0 +1,219 @@
+package purge
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/validation"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	descriptionShort = `Purge Akamai paths from the command line`
+	descriptionLong  = `
+	Purge Akamai paths from the command line, through a running akapurgo server (--server and --api-key)
+	or directly with the Akamai credentials of a config file (--config).
+	Paths are read from the arguments, from a file (-f), or from stdin ("-f -" or when no path is given).
+	Empty lines and lines starting with # are ignored.
+
+	Exit codes:
+	  0  purge succeeded (or dry run planned)
+	  1  purge failed
+	  2  invalid flags, paths or configuration
+	  3  purge partially failed, some batches were rejected
+	  4  purge waiting for approval
+	  5  authentication or authorization failed`
+
+	defaultTimeout = 5 * time.Minute
+
+	// Environment variables read when the flags are not set, to keep the API key out of the command line
+	ServerEnvVar = "AKAPURGO_SERVER"
+	APIKeyEnvVar = "AKAPURGO_API_KEY"
+
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// Exit codes of the command, for the pipelines to branch on
+const (
+	ExitSucceeded = 0
+	ExitFailed    = 1
+	ExitInvalid   = 2
+	ExitPartial   = 3
+	ExitPending   = 4
+	ExitDenied    = 5
+)
+
+// exitError is an error ending the command with a given exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "purge [flags] [paths...]",
+		DisableFlagsInUseLine: true,
+		Short:                 descriptionShort,
+		Long:                  strings.ReplaceAll(descriptionLong, "\t", ""),
+
+		Run: PurgeCommand,
+	}
+
+	cmd.Flags().String("type", "urls", "Purge type: urls, cache-tags or cpcodes")
+	cmd.Flags().String("action", "invalidate", "Action type: invalidate or delete")
+	cmd.Flags().String("env", "staging", "Environment: production or staging")
+	cmd.Flags().StringP("file", "f", "", "File to read the paths from, one per line. - reads stdin")
+	cmd.Flags().String("account", "", "Akamai account, routed by hostname when empty")
+	cmd.Flags().Bool("post-purge-request", false, "Send GET requests to the purged URLs")
+	cmd.Flags().Bool("dry-run", false, "Plan the purge without calling Akamai")
+	cmd.Flags().StringP("output", "o", OutputTable, "Output format: table or json")
+
+	cmd.Flags().String("server", "", "URL of the akapurgo server. Defaults to $"+ServerEnvVar)
+	cmd.Flags().String("api-key", "", "API key for the akapurgo server. Defaults to $"+APIKeyEnvVar)
+	cmd.Flags().Duration("timeout", defaultTimeout, "Timeout of the request to the akapurgo server")
+
+	cmd.Flags().String("config", "config.yaml", "Path to the YAML config file, to call Akamai directly when no server is set")
+	cmd.Flags().String("log-level", "error", "Verbosity level for logs, when calling Akamai directly")
+
+	return cmd
+}
+
+func PurgeCommand(cmd *cobra.Command, args []string) {
+	result, err := purge(cmd, args)
+
+	code := ExitSucceeded
+	var exitErr *exitError
+	switch {
+	case errors.As(err, &exitErr):
+		fmt.Fprintf(os.Stderr, "Error: %v\n", exitErr.err)
+		code = exitErr.code
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		code = ExitFailed
+	}
+
+	if result != nil {
+		output, _ := cmd.Flags().GetString("output")
+		if err := printResult(os.Stdout, output, result); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		code = max(code, result.ExitCode())
+	}
+
+	os.Exit(code)
+}
+
+// purge builds the request from the flags and paths, and sends it to the server or to Akamai
+func purge(cmd *cobra.Command, args []string) (*Result, error) {
+	flags := cmd.Flags()
+
+	output, _ := flags.GetString("output")
+	if output != OutputTable && output != OutputJSON {
+		return nil, &exitError{ExitInvalid, fmt.Errorf("invalid output '%s', must be one of: table, json", output)}
+	}
+
+	file, _ := flags.GetString("file")
+	paths, err := readPaths(args, file, os.Stdin)
+	if err != nil {
+		return nil, &exitError{ExitInvalid, err}
+	}
+
+	req := v1alpha1.PurgeRequest{Paths: paths}
+	req.PurgeType, _ = flags.GetString("type")
+	req.ActionType, _ = flags.GetString("action")
+	req.Environment, _ = flags.GetString("env")
+	req.Account, _ = flags.GetString("account")
+	req.PostPurgeRequest, _ = flags.GetBool("post-purge-request")
+	req.DryRun, _ = flags.GetBool("dry-run")
+
+	// Fail fast, with the same checks as the server
+	if validationErrors := validation.ValidatePurgeRequest(req); len(validationErrors) > 0 {
+		return nil, &exitError{ExitInvalid, fmt.Errorf("invalid purge request: %s", formatValidationErrors(validationErrors))}
+	}
+
+	server, _ := flags.GetString("server")
+	if server == "" {
+		server = os.Getenv(ServerEnvVar)
+	}
+
+	if server != "" {
+		apiKey, _ := flags.GetString("api-key")
+		if apiKey == "" {
+			apiKey = os.Getenv(APIKeyEnvVar)
+		}
+		timeout, _ := flags.GetDuration("timeout")
+
+		return purgeRemote(server, apiKey, timeout, req)
+	}
+
+	configPath, _ := flags.GetString("config")
+	logLevel, _ := flags.GetString("log-level")
+
+	return purgeDirect(configPath, logLevel, req)
+}
+
+// readPaths returns the paths from the arguments, then from the file. Stdin is read when the file is "-",
+// or when no path is given at all
+func readPaths(args []string, file string, stdin io.Reader) (paths []string, err error) {
+	paths = append(paths, args...)
+
+	var reader io.Reader
+	switch {
+	case file == "-" || (file == "" && len(args) == 0):
+		reader = stdin
+	case file != "":
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	if reader != nil {
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			paths = append(paths, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read paths: %v", err)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, errors.New("no path to purge")
+	}
+
+	return paths, nil
+}
+
+func formatValidationErrors(validationErrors []v1alpha1.ValidationError) string {
+	messages := make([]string, 0, len(validationErrors))
+	for _, validationError := range validationErrors {
+		field := validationError.Field
+		if validationError.Index != nil {
+			field = fmt.Sprintf("%s[%d]", field, *validationError.Index)
+		}
+		if validationError.Value != "" {
+			field = fmt.Sprintf("%s '%s'", field, validationError.Value)
+		}
+		messages = append(messages, field+": "+validationError.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
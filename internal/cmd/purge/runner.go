@@ -0,0 +1,168 @@
+package purge
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/akamai"
+	"akapurgo/internal/auth"
+	"akapurgo/internal/config"
+	"akapurgo/internal/globals"
+	purgeservice "akapurgo/internal/purge"
+	"akapurgo/internal/ratelimit"
+	"akapurgo/internal/secrets"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of a purge: the combined Akamai response, the plan of a dry run,
+// or the record of a purge waiting for approval
+type Result struct {
+	Response *v1alpha1.PurgeResponse
+	Plan     *v1alpha1.PurgePlan
+	Pending  *v1alpha1.PurgeRecord
+}
+
+// ExitCode returns the exit code matching the outcome of the purge
+func (r *Result) ExitCode() int {
+	switch {
+	case r.Pending != nil:
+		return ExitPending
+	case r.Response == nil:
+		return ExitSucceeded
+	case r.Response.HTTPStatus == http.StatusMultiStatus:
+		return ExitPartial
+	case r.Response.HTTPStatus >= 200 && r.Response.HTTPStatus < 300:
+		return ExitSucceeded
+	default:
+		return ExitFailed
+	}
+}
+
+// serverError is the body of the errors returned by the akapurgo server
+type serverError struct {
+	Error  string                     `json:"error"`
+	Errors []v1alpha1.ValidationError `json:"errors"`
+	Reason string                     `json:"reason"`
+}
+
+// purgeRemote sends the purge request to a running akapurgo server, authenticated with the API key
+func purgeRemote(server string, apiKey string, timeout time.Duration, req v1alpha1.PurgeRequest) (*Result, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest("POST", strings.TrimSuffix(server, "/")+"/api/v1/purge", bytes.NewReader(body))
+	if err != nil {
+		return nil, &exitError{ExitInvalid, fmt.Errorf("invalid server URL: %v", err)}
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		request.Header.Set(auth.APIKeyHeader, apiKey)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call akapurgo server: %v", err)
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read akapurgo server response: %v", err)
+	}
+
+	// Errors of the server itself, as opposed to the errors returned by Akamai in a purge response
+	var errorBody serverError
+	if err := json.Unmarshal(responseBody, &errorBody); err != nil {
+		return nil, fmt.Errorf("unexpected response from akapurgo server: status code %d", response.StatusCode)
+	}
+	if errorBody.Error != "" {
+		message := errorBody.Error
+		if len(errorBody.Errors) > 0 {
+			message += ": " + formatValidationErrors(errorBody.Errors)
+		}
+		if errorBody.Reason != "" {
+			message += ": " + errorBody.Reason
+		}
+
+		switch response.StatusCode {
+		case http.StatusBadRequest:
+			return nil, &exitError{ExitInvalid, errors.New(message)}
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return nil, &exitError{ExitDenied, errors.New(message)}
+		default:
+			return nil, fmt.Errorf("%s (status code %d)", message, response.StatusCode)
+		}
+	}
+
+	result := &Result{}
+	switch {
+	case response.StatusCode == http.StatusAccepted:
+		result.Pending = &v1alpha1.PurgeRecord{}
+		err = json.Unmarshal(responseBody, result.Pending)
+	case req.DryRun:
+		result.Plan = &v1alpha1.PurgePlan{}
+		err = json.Unmarshal(responseBody, result.Plan)
+	default:
+		result.Response = &v1alpha1.PurgeResponse{}
+		err = json.Unmarshal(responseBody, result.Response)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode akapurgo server response: %v", err)
+	}
+
+	return result, nil
+}
+
+// purgeDirect sends the purge request to Akamai with the credentials of the config file.
+// The policies and approvals of the server do not apply, as the caller holds the credentials
+func purgeDirect(configPath string, logLevel string, req v1alpha1.PurgeRequest) (*Result, error) {
+	configContent, err := config.Load(configPath)
+	if err != nil {
+		return nil, &exitError{ExitInvalid, fmt.Errorf("impossible to parse config file: %v", err)}
+	}
+
+	logger, err := globals.GetLogger(logLevel, true)
+	if err != nil {
+		return nil, &exitError{ExitInvalid, err}
+	}
+	ctx := v1alpha1.NewContext(configContent, logger)
+
+	credentials, err := akamai.NewCredentials(configContent, secrets.NewResolver(configContent.Secrets))
+	if err != nil {
+		return nil, &exitError{ExitInvalid, fmt.Errorf("error loading Akamai credentials: %v", err)}
+	}
+
+	purger := purgeservice.NewPurger(ctx, ratelimit.NewLimiter(ctx), credentials)
+
+	if req.DryRun {
+		plan, err := purger.Plan(req)
+		if err != nil {
+			return nil, purgeError(err)
+		}
+		return &Result{Plan: &plan}, nil
+	}
+
+	response, err := purger.Run(req, nil)
+	if err != nil {
+		return nil, purgeError(err)
+	}
+
+	return &Result{Response: &response}, nil
+}
+
+// purgeError returns the exit error matching an error returned by the purger
+func purgeError(err error) error {
+	if errors.Is(err, purgeservice.ErrInvalidRequest) {
+		return &exitError{ExitInvalid, err}
+	}
+
+	return err
+}
@@ -1,5 +0,0 @@
-package run
-
-const (
-	defaultListenAddress = ":8080"
-)
@@ -0,0 +1,149 @@
+package run
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/akamai"
+	"akapurgo/internal/auth"
+	"akapurgo/internal/config"
+	"akapurgo/internal/secrets"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// reloader reloads the config file when it changes or on SIGHUP. The new configuration is validated
+// before being swapped in, and the current one is kept when it is invalid
+type reloader struct {
+	ctx           v1alpha1.Context
+	path          string
+	credentials   *akamai.Credentials
+	authenticator *auth.Authenticator
+
+	// content is the last content read from the config file, to detect its changes
+	content []byte
+}
+
+func newReloader(ctx v1alpha1.Context, path string, credentials *akamai.Credentials,
+	authenticator *auth.Authenticator) (*reloader, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reloader{ctx: ctx, path: path, credentials: credentials, authenticator: authenticator, content: content}, nil
+}
+
+// Watch reloads the configuration on SIGHUP, and when the content of the file changes, checked every interval.
+// A zero interval disables the checks
+func (r *reloader) Watch(interval time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	var ticks <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for {
+		select {
+		case <-signals:
+			r.ctx.Logger.Infof("Received SIGHUP, reloading the configuration from %s", r.path)
+		case <-ticks:
+			content, err := os.ReadFile(r.path)
+			if err != nil {
+				r.ctx.Logger.Errorf("Failed to read the config file %s: %v", r.path, err)
+				continue
+			}
+			if bytes.Equal(content, r.content) {
+				continue
+			}
+			r.content = content
+			r.ctx.Logger.Infof("Config file %s changed, reloading the configuration", r.path)
+		}
+
+		if err := r.reload(); err != nil {
+			r.ctx.Logger.Errorf("Failed to reload the configuration, keeping the current one: %v", err)
+		}
+	}
+}
+
+// reload validates the config file and swaps it in, along with the Akamai credentials and the API keys built from it.
+// The credentials are swapped before the configuration, so that purges never run with the new configuration
+// and the old credentials
+func (r *reloader) reload() error {
+	current := r.ctx.Config()
+
+	next, err := config.Load(r.path)
+	if err != nil {
+		return fmt.Errorf("impossible to parse config file: %v", err)
+	}
+
+	if changed := keepStartupSections(current, next); len(changed) > 0 {
+		r.ctx.Logger.Warnf("Changes to %v are only applied on restart", changed)
+	}
+
+	// Pending purges are kept in the history, and their requesters must be authenticated
+	if next.Approvals.Enabled && !next.History.Enabled {
+		return errors.New("approvals require the purge history to be enabled")
+	}
+	if next.Approvals.Enabled && !next.Auth.Required {
+		return errors.New("approvals require the authentication to be required")
+	}
+
+	credentials, err := akamai.NewCredentials(next, secrets.NewResolver(next.Secrets))
+	if err != nil {
+		return fmt.Errorf("error loading Akamai credentials: %v", err)
+	}
+
+	// Nothing is swapped when the API keys are invalid, and nothing can fail once they are
+	if err := r.authenticator.ReloadAPIKeys(next.Auth.APIKeys); err != nil {
+		return fmt.Errorf("error loading API keys: %v", err)
+	}
+	r.credentials.Replace(credentials)
+	r.ctx.SetConfig(next)
+
+	r.ctx.Logger.Infof("Configuration reloaded from %s", r.path)
+	return nil
+}
+
+// keepStartupSections copies into the next configuration the sections of the current one that are only read
+// at startup, by the webserver, the authenticator, the rate limiter and the stores, and returns those that changed.
+// The API keys themselves are reloaded, only enabling or disabling them needs a restart
+func keepStartupSections(current, next *v1alpha1.ConfigSpec) (changed []string) {
+	if !reflect.DeepEqual(current.Server, next.Server) {
+		changed = append(changed, "server")
+		next.Server = current.Server
+	}
+
+	apiKeys := next.Auth.APIKeys
+	next.Auth.APIKeys.Keys, next.Auth.APIKeys.File = current.Auth.APIKeys.Keys, current.Auth.APIKeys.File
+	if !reflect.DeepEqual(current.Auth, next.Auth) {
+		changed = append(changed, "auth")
+		next.Auth = current.Auth
+	}
+	next.Auth.APIKeys.Keys, next.Auth.APIKeys.File = apiKeys.Keys, apiKeys.File
+
+	if !reflect.DeepEqual(current.RateLimit, next.RateLimit) {
+		changed = append(changed, "rate_limit")
+		next.RateLimit = current.RateLimit
+	}
+
+	if !reflect.DeepEqual(current.Jobs, next.Jobs) {
+		changed = append(changed, "jobs")
+		next.Jobs = current.Jobs
+	}
+
+	if !reflect.DeepEqual(current.History, next.History) {
+		changed = append(changed, "history")
+		next.History = current.History
+	}
+
+	return changed
+}
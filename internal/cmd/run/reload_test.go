@@ -0,0 +1,99 @@
+package run
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/akamai"
+	"akapurgo/internal/auth"
+	"akapurgo/internal/config"
+	"akapurgo/internal/secrets"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// reloadTestConfig is a config file granting a single API key, named after its owner
+const reloadTestConfig = `
+akamai:
+  host: "https://akab-host.luna.akamaiapis.net"
+  client_secret: "secret"
+  client_token: "client-token"
+  access_token: "access-token"
+auth:
+  required: true
+  api_keys:
+    enabled: true
+    keys:
+      - name: %s
+        hash: "%s"
+`
+
+func writeReloadTestConfig(t *testing.T, path, name string) {
+	t.Helper()
+
+	content := fmt.Sprintf(reloadTestConfig, name, auth.HashAPIKey(name+"-key"))
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeReloadTestConfig(t, path, "revoked")
+
+	configContent, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := v1alpha1.NewContext(configContent, zap.NewNop().Sugar())
+	credentials, err := akamai.NewCredentials(configContent, secrets.NewResolver(configContent.Secrets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := auth.NewAuthenticator(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configReloader, err := newReloader(ctx, path, credentials, authenticator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Use(authenticator.Middleware())
+	app.Get("/", auth.RequireAuthentication(ctx), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	status := func(key string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(auth.APIKeyHeader, key)
+		response, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.StatusCode
+	}
+
+	if status("revoked-key") != fiber.StatusOK {
+		t.Fatal("expected the key to be valid before the reload")
+	}
+
+	writeReloadTestConfig(t, path, "added")
+	if err := configReloader.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := status("revoked-key"); code != fiber.StatusUnauthorized {
+		t.Errorf("expected the revoked key rejected after the reload, got %d", code)
+	}
+	if code := status("added-key"); code != fiber.StatusOK {
+		t.Errorf("expected the added key accepted after the reload, got %d", code)
+	}
+	if keys := ctx.Config().Auth.APIKeys.Keys; len(keys) != 1 || keys[0].Name != "added" {
+		t.Errorf("expected the reloaded keys in the configuration, got %v", keys)
+	}
+}
@@ -2,31 +2,42 @@ package run
 
 import (
 	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/akamai"
 	"akapurgo/internal/api"
+	"akapurgo/internal/auth"
 	"akapurgo/internal/commons"
 	"akapurgo/internal/config"
 	"akapurgo/internal/globals"
+	"akapurgo/internal/jobs"
+	"akapurgo/internal/purge"
+	"akapurgo/internal/ratelimit"
+	"akapurgo/internal/secrets"
+	"akapurgo/internal/storage"
 	"fmt"
 	"github.com/spf13/cobra"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/template/html/v2"
 )
 
 const (
+	defaultReloadInterval = 10 * time.Second
+
 	descriptionShort = `Run akapurgo webserver`
 	descriptionLong  = `
 	Run akapurgo webserver`
 
 	//
-	ConfigFlagErrorMessage       = "impossible to get flag --config: %s"
-	ConfigNotParsedErrorMessage  = "impossible to parse config file: %s"
-	LogLevelFlagErrorMessage     = "impossible to get flag --log-level: %s"
-	DisableTraceFlagErrorMessage = "impossible to get flag --disable-trace: %s"
+	ConfigFlagErrorMessage         = "impossible to get flag --config: %s"
+	ConfigNotParsedErrorMessage    = "impossible to parse config file: %s"
+	LogLevelFlagErrorMessage       = "impossible to get flag --log-level: %s"
+	DisableTraceFlagErrorMessage   = "impossible to get flag --disable-trace: %s"
+	ReloadIntervalFlagErrorMessage = "impossible to get flag --reload-interval: %s"
 )
 
 func NewCommand() *cobra.Command {
@@ -42,6 +53,8 @@ func NewCommand() *cobra.Command {
 	cmd.Flags().String("config", "config.yaml", "Path to the YAML config file")
 	cmd.Flags().String("log-level", "info", "Verbosity level for logs")
 	cmd.Flags().Bool("disable-trace", true, "Disable showing traces in logs")
+	cmd.Flags().Duration("reload-interval", defaultReloadInterval,
+		"Interval to check the config file for changes. 0 only reloads it on SIGHUP")
 
 	return cmd
 }
@@ -65,38 +78,48 @@ func RunCommand(cmd *cobra.Command, args []string) {
 		log.Fatalf(DisableTraceFlagErrorMessage, err)
 	}
 
+	reloadInterval, err := cmd.Flags().GetDuration("reload-interval")
+	if err != nil {
+		log.Fatalf(ReloadIntervalFlagErrorMessage, err)
+	}
+
 	//
 	logger, err := globals.GetLogger(logLevelFlag, disableTraceFlag)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Configure application's context
-	ctx := v1alpha1.Context{
-		Config: &v1alpha1.ConfigSpec{},
-		Logger: logger,
-	}
-
-	// Get and parse the config
-	configContent, err := config.ReadFile(configPath)
+	// Get and parse the config, with its default values
+	configContent, err := config.Load(configPath)
 	if err != nil {
 		logger.Fatalf(fmt.Sprintf(ConfigNotParsedErrorMessage, err))
 	}
 
-	// Set the configuration inside the global context
-	ctx.Config = &configContent
+	// Configure application's context
+	ctx := v1alpha1.NewContext(configContent, logger)
 
-	// Load default values
-	if ctx.Config.Server.ListenAddress == "" {
-		ctx.Config.Server.ListenAddress = defaultListenAddress
-	}
+	ctx.Logger.Info("Starting Akapurgo webserver in ", ctx.Config().Server.ListenAddress)
 
-	ctx.Logger.Info("Starting Akapurgo webserver in ", ctx.Config.Server.ListenAddress)
+	// Build the Akamai signers in memory, resolving the secret references, and refresh them
+	// to pick up rotated secrets
+	credentials, err := akamai.NewCredentials(ctx.Config(), secrets.NewResolver(ctx.Config().Secrets))
+	if err != nil {
+		ctx.Logger.Fatalf("Error loading Akamai credentials: %v", err)
+	}
+	go credentials.Watch(ctx)
 
-	// Create the akamai config file if not exists
-	err = config.CreateAkamaiConfigFile(ctx)
+	// Open the purge history store
+	store, err := storage.NewStore(ctx)
 	if err != nil {
-		ctx.Logger.Fatalf("Error creating Akamai config file: %v", err)
+		ctx.Logger.Fatalf("Error opening the purge history: %v", err)
+	}
+	if store != nil {
+		defer store.Close()
+	}
+
+	// Pending purges are kept in the history
+	if ctx.Config().Approvals.Enabled && store == nil {
+		ctx.Logger.Fatal("Approvals require the purge history to be enabled")
 	}
 
 	// Get the base path for the templates and static files
@@ -115,8 +138,8 @@ func RunCommand(cmd *cobra.Command, args []string) {
 		Views: engine,
 	}
 
-	if ctx.Config.Server.Config.ReadBufferSize != 0 {
-		fiberConfig.ReadBufferSize = ctx.Config.Server.Config.ReadBufferSize
+	if ctx.Config().Server.Config.ReadBufferSize != 0 {
+		fiberConfig.ReadBufferSize = ctx.Config().Server.Config.ReadBufferSize
 	}
 
 	app := fiber.New(fiberConfig)
@@ -124,19 +147,53 @@ func RunCommand(cmd *cobra.Command, args []string) {
 	// Log requests
 	app.Use(commons.LogRequest(ctx))
 
+	// Authenticate requests
+	authenticator, err := auth.NewAuthenticator(ctx)
+	if err != nil {
+		ctx.Logger.Fatalf("Error creating the authenticator: %v", err)
+	}
+	app.Use(authenticator.Middleware())
+
 	// Define the routes
 
-	// Static pages
-	app.Get("/", func(c *fiber.Ctx) error {
-		return c.Render("index", fiber.Map{})
-	})
+	// Static pages. When the OIDC login is enabled, the users must be logged in to see them.
+	// They require the same authentication and scopes as the API they show the data of
+	requireLogin := func(c *fiber.Ctx) error { return c.Next() }
+	if oidc := authenticator.OIDC(); oidc != nil {
+		oidc.Register(app)
+		requireLogin = oidc.RequireLogin()
+	}
+	requireAuthentication := auth.RequireAuthentication(ctx)
+
+	app.Get("/", requireLogin, requireAuthentication, api.IndexPage(ctx, store))
+	app.Get("/history", requireLogin, requireAuthentication, auth.RequireScope(auth.ScopeHistoryRead),
+		api.HistoryPage(ctx, store))
+	app.Get("/approvals", requireLogin, requireAuthentication, auth.RequireScope(auth.ScopeHistoryRead),
+		api.ApprovalsPage(ctx, store))
 	app.Static("/static", staticPath)
 
 	// API
-	app.Post("/api/v1/purge", api.PurgeHandler(ctx))
+	// The rate limiter and the purge jobs are shared by all the purge requests
+	purger := purge.NewPurger(ctx, ratelimit.NewLimiter(ctx), credentials)
+	jobStore := jobs.NewStore(ctx.Config().Jobs.Retention)
+	apiV1 := app.Group("/api/v1", requireAuthentication)
+	apiV1.Post("/purge", api.PurgeHandler(ctx, purger, jobStore, store))
+	apiV1.Get("/purge/:id", api.JobHandler(ctx, jobStore))
+	apiV1.Get("/purges", auth.RequireScope(auth.ScopeHistoryRead), api.HistoryHandler(ctx, store))
+	apiV1.Post("/purges/:id/approve", auth.RequireScope(auth.ScopeApprovalsReview),
+		api.ReviewHandler(ctx, purger, jobStore, store, true))
+	apiV1.Post("/purges/:id/reject", auth.RequireScope(auth.ScopeApprovalsReview),
+		api.ReviewHandler(ctx, purger, jobStore, store, false))
+
+	// Reload the configuration when the file changes or on SIGHUP
+	configReloader, err := newReloader(ctx, configPath, credentials, authenticator)
+	if err != nil {
+		ctx.Logger.Fatalf("Error watching the config file: %v", err)
+	}
+	go configReloader.Watch(reloadInterval)
 
 	// Start the webserver
-	err = app.Listen(ctx.Config.Server.ListenAddress)
+	err = app.Listen(ctx.Config().Server.ListenAddress)
 	if err != nil {
 		ctx.Logger.Fatalf("Error starting the webserver: %v", err)
 	}
@@ -0,0 +1,63 @@
+package validateconfig
+
+import (
+	"akapurgo/internal/config"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	descriptionShort = `Validate akapurgo config file`
+	descriptionLong  = `
+	Validate akapurgo config file with the checks done by run at startup.
+	Every problem is printed with its YAML path, and the command exits with a non-zero status when there is any`
+
+	//
+	ConfigFlagErrorMessage = "impossible to get flag --config: %s"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                   "validate-config",
+		DisableFlagsInUseLine: true,
+		Short:                 descriptionShort,
+		Long:                  strings.ReplaceAll(descriptionLong, "\t", ""),
+
+		Run: ValidateConfigCommand,
+	}
+
+	cmd.Flags().String("config", "config.yaml", "Path to the YAML config file")
+
+	return cmd
+}
+
+func ValidateConfigCommand(cmd *cobra.Command, args []string) {
+
+	// Check the flags for this command
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, ConfigFlagErrorMessage+"\n", err)
+		os.Exit(2)
+	}
+
+	_, err = config.ReadFile(configPath)
+
+	var configError *config.Error
+	switch {
+	case errors.As(err, &configError):
+		for _, problem := range configError.Problems {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", problem.Field, problem.Message)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d problems found\n", configPath, len(configError.Problems))
+		os.Exit(1)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "%s: %v\n", configPath, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s: configuration is valid\n", configPath)
+}
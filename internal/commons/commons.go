@@ -4,6 +4,7 @@ import (
 	"akapurgo/api/v1alpha1"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"github.com/valyala/fasthttp"
 	"regexp"
@@ -13,7 +14,11 @@ import (
 )
 
 const (
-	AkamaiConfigPath = "/tmp/.edgerc"
+	// IdentityLocalsKey is the key of the request locals holding the identity of the authenticated caller
+	IdentityLocalsKey = "identity"
+
+	// AuthErrorLocalsKey is the key of the request locals holding why the credentials of the caller were rejected
+	AuthErrorLocalsKey = "auth-error"
 
 	RequestPartsPattern   = `REQUEST:([^\}]+)`
 	RequestHeaderPattern  = `REQUEST_HEADER:([^\}]+)`
@@ -76,13 +81,9 @@ func replaceRequestHeaderTags(req *fasthttp.Request, textToProcess string) (resu
 }
 
 // GetRequestLogFields returns the fields attached to a log message for the given HTTP request
-func GetRequestLogFields(req *fasthttp.Request, configurationFields []string, ctx v1alpha1.Context) []interface{} {
+func GetRequestLogFields(req *fasthttp.Request, configurationFields []string) []interface{} {
 	var logFields []interface{}
 
-	if ctx.Config.Logs.JwtUser.Enabled {
-		logFields = addJwtUser(ctx, logFields, req)
-	}
-
 	for _, field := range configurationFields {
 
 		result := replaceRequestTags(req, field)
@@ -172,43 +173,101 @@ func GetResponseLogFields(resp *fasthttp.Response, configurationFields []string,
 	return logFields
 }
 
-// addJwtUser
+// GetJwtUser returns the user stored in the configured field of the JWT sent in the request.
+// An empty user is returned when the request does not carry a JWT
+func GetJwtUser(ctx v1alpha1.Context, req *fasthttp.Request) (user string, err error) {
+	cookie := string(req.Header.Peek(ctx.Config().Logs.JwtUser.Header))
+	if cookie == "" {
+		return user, nil
+	}
+
+	jwtPayload := strings.Split(cookie, ".")
+	if len(jwtPayload) != 3 {
+		return user, fmt.Errorf("invalid JWT format: expected 3 parts but got %d", len(jwtPayload))
+	}
+
+	jwtPart := strings.TrimSpace(jwtPayload[1])
+	jwtPart = strings.ReplaceAll(jwtPart, "\n", "")
+	jwtPart = strings.ReplaceAll(jwtPart, "\r", "")
+	jwtPart = strings.ReplaceAll(jwtPart, " ", "")
+
+	jwtDecoded, err := base64.RawURLEncoding.DecodeString(jwtPart)
+	if err != nil {
+		return user, fmt.Errorf("failed to decode JWT payload: %v", err)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(jwtDecoded, &payload); err != nil {
+		return user, fmt.Errorf("failed to parse JWT payload: %v", err)
+	}
+
+	user, _ = payload[ctx.Config().Logs.JwtUser.JwtField].(string)
+	return user, nil
+}
+
+// addJwtUser adds the user extracted from the JWT to the log fields
 func addJwtUser(ctx v1alpha1.Context, logFields []interface{}, req *fasthttp.Request) []interface{} {
-	cookie := string(req.Header.Peek(ctx.Config.Logs.JwtUser.Header))
-	if cookie != "" {
-		jwtPayload := strings.Split(cookie, ".")
-		if len(jwtPayload) != 3 {
-			ctx.Logger.Errorf("Invalid JWT format: expected 3 parts but got %d\n", len(jwtPayload))
-			return logFields
-		}
+	user, err := GetJwtUser(ctx, req)
+	if err != nil {
+		ctx.Logger.Errorf("Failed to get the JWT user: %v\n", err)
+		return logFields
+	}
 
-		jwtPart := strings.TrimSpace(jwtPayload[1])
-		jwtPart = strings.ReplaceAll(jwtPart, "\n", "")
-		jwtPart = strings.ReplaceAll(jwtPart, "\r", "")
-		jwtPart = strings.ReplaceAll(jwtPart, " ", "")
+	if user != "" {
+		logFields = append(logFields, "jwt_user", user)
+	}
 
-		jwtDecoded, err := base64.RawURLEncoding.DecodeString(jwtPart)
-		if err != nil {
-			ctx.Logger.Errorf("Failed to decode JWT payload: %v\n", err)
-			return logFields
-		}
+	return logFields
+}
 
-		var payload map[string]interface{}
-		if err := json.Unmarshal(jwtDecoded, &payload); err != nil {
-			ctx.Logger.Errorf("Failed to parse JWT payload: %v\n", err)
-			return logFields
-		}
+// addUser adds the user making the request to the log fields, exactly once. The verified identity is used
+// when present, and the user is only decoded from the JWT when nobody is authenticated and the tokens are not verified
+func addUser(ctx v1alpha1.Context, logFields []interface{}, c *fiber.Ctx) []interface{} {
+	if identity, ok := GetIdentity(c); ok {
+		return append(logFields, identityLogField(identity), identity.User)
+	}
 
-		user, ok := payload[ctx.Config.Logs.JwtUser.JwtField].(string)
-		if ok {
-			logFields = append(logFields, "jwt_user", user)
-			return logFields
-		}
+	if ctx.Config().Logs.JwtUser.Enabled && !ctx.Config().Auth.JWT.Enabled {
+		return addJwtUser(ctx, logFields, c.Request())
 	}
 
 	return logFields
 }
 
+// GetIdentity returns the identity of the authenticated caller of the request
+func GetIdentity(c *fiber.Ctx) (identity v1alpha1.Identity, ok bool) {
+	identity, ok = c.Locals(IdentityLocalsKey).(v1alpha1.Identity)
+	return identity, ok
+}
+
+// GetUser returns the user making the request. The authenticated identity is used when present,
+// falling back to the user decoded from the JWT when the tokens are not verified
+func GetUser(ctx v1alpha1.Context, c *fiber.Ctx) string {
+	if identity, ok := GetIdentity(c); ok {
+		return identity.User
+	}
+
+	if ctx.Config().Auth.JWT.Enabled {
+		return ""
+	}
+
+	user, err := GetJwtUser(ctx, c.Request())
+	if err != nil {
+		ctx.Logger.Errorf("Failed to get the JWT user: %v\n", err)
+	}
+
+	return user
+}
+
+// identityLogField returns the name of the log field for the identity, depending on how it was authenticated
+func identityLogField(identity v1alpha1.Identity) string {
+	if identity.Method == v1alpha1.AuthMethodAPIKey {
+		return "api_key"
+	}
+
+	return "jwt_user"
+}
+
 // LogRequest logs the request and response of a given HTTP request
 func LogRequest(ctx v1alpha1.Context) fiber.Handler {
 	return func(c *fiber.Ctx) error {
@@ -222,10 +281,10 @@ func LogRequest(ctx v1alpha1.Context) fiber.Handler {
 		duration := time.Since(start)
 
 		// Log the request
-		if ctx.Config.Logs.ShowAccessLogs {
-			logFieldsReq := GetResponseLogFields(c.Response(), ctx.Config.Logs.AccessLogsFields, duration)
-			logFieldsResp := GetRequestLogFields(c.Request(), ctx.Config.Logs.AccessLogsFields, ctx)
-			logFields := append(logFieldsReq, logFieldsResp...)
+		if ctx.Config().Logs.ShowAccessLogs {
+			logFieldsReq := GetResponseLogFields(c.Response(), ctx.Config().Logs.AccessLogsFields, duration)
+			logFieldsResp := GetRequestLogFields(c.Request(), ctx.Config().Logs.AccessLogsFields)
+			logFields := addUser(ctx, append(logFieldsReq, logFieldsResp...), c)
 			ctx.Logger.Infow("request", logFields...)
 		}
 
@@ -0,0 +1,68 @@
+package commons
+
+import (
+	"akapurgo/api/v1alpha1"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogRequestUser(t *testing.T) {
+	forgedJWT := "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + ".forged"
+
+	tests := []struct {
+		name     string
+		identity *v1alpha1.Identity
+		field    string
+		user     string
+	}{
+		{"API key", &v1alpha1.Identity{User: "pipeline", Method: v1alpha1.AuthMethodAPIKey}, "api_key", "pipeline"},
+		{"OIDC", &v1alpha1.Identity{User: "alice", Method: v1alpha1.AuthMethodOIDC}, "jwt_user", "alice"},
+		{"unauthenticated", nil, "jwt_user", "mallory"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &v1alpha1.ConfigSpec{}
+			config.Logs.ShowAccessLogs = true
+			config.Logs.JwtUser.Enabled = true
+			config.Logs.JwtUser.Header = "X-Jwt"
+			config.Logs.JwtUser.JwtField = "sub"
+			core, logs := observer.New(zapcore.InfoLevel)
+			ctx := v1alpha1.NewContext(config, zap.New(core).Sugar())
+
+			app := fiber.New()
+			app.Use(LogRequest(ctx))
+			app.Get("/", func(c *fiber.Ctx) error {
+				if test.identity != nil {
+					c.Locals(IdentityLocalsKey, *test.identity)
+				}
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Jwt", forgedJWT)
+			if _, err := app.Test(req, -1); err != nil {
+				t.Fatal(err)
+			}
+
+			if logs.Len() != 1 {
+				t.Fatalf("expected 1 log line, got %d", logs.Len())
+			}
+			var users []string
+			for _, field := range logs.All()[0].Context {
+				if field.Key == "api_key" || field.Key == "jwt_user" {
+					users = append(users, field.Key+"="+field.String)
+				}
+			}
+			if len(users) != 1 || users[0] != test.field+"="+test.user {
+				t.Errorf("expected only %s=%s, got %v", test.field, test.user, users)
+			}
+		})
+	}
+}
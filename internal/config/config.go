@@ -1,16 +1,37 @@
 package config
 
 import (
-	"gopkg.in/yaml.v3"
+	"fmt"
 	"os"
+	"slices"
 
 	"akapurgo/api/v1alpha1"
 )
 
-// Unmarshal TODO
+// Unmarshal decodes the configuration strictly and runs its semantic checks.
+// Unknown fields, values of the wrong type and wrong values are all returned in an *Error
 func Unmarshal(bytes []byte) (config v1alpha1.ConfigSpec, err error) {
-	err = yaml.Unmarshal(bytes, &config)
-	return config, err
+	config, lines, errs, err := decodeStrict(bytes)
+	if err != nil {
+		return config, err
+	}
+
+	// Fields already reported by the decoding are not checked again
+	for _, problem := range Validate(&config) {
+		if slices.ContainsFunc(errs, func(e v1alpha1.ValidationError) bool { return e.Field == problem.Field }) {
+			continue
+		}
+		if line, found := lines[problem.Field]; found {
+			problem.Message = fmt.Sprintf("%s (line %d)", problem.Message, line)
+		}
+		errs = append(errs, problem)
+	}
+
+	if len(errs) > 0 {
+		return config, &Error{Problems: errs}
+	}
+
+	return config, nil
 }
 
 // ReadFile TODO
@@ -28,3 +49,14 @@ func ReadFile(filepath string) (config v1alpha1.ConfigSpec, err error) {
 
 	return config, err
 }
+
+// Load reads the config file, checks it, and fills it with the default values
+func Load(filepath string) (*v1alpha1.ConfigSpec, error) {
+	config, err := ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	SetDefaults(&config)
+	return &config, nil
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"akapurgo/api/v1alpha1"
+	"time"
+)
+
+const (
+	defaultListenAddress = ":8080"
+
+	defaultAkamaiRequestTimeout      = 30 * time.Second
+	defaultAkamaiRetryMaxAttempts    = 3
+	defaultAkamaiRetryInitialBackoff = 1 * time.Second
+	defaultAkamaiRetryMaxBackoff     = 30 * time.Second
+
+	defaultSecretsRefreshInterval = 30 * time.Second
+	defaultSecretsTimeout         = 10 * time.Second
+
+	defaultRateLimitMode         = "queue"
+	defaultRateLimitQueueTimeout = 30 * time.Second
+
+	defaultJobsRetention = 1 * time.Hour
+
+	defaultPostPurgeDelay               = 5 * time.Second
+	defaultPostPurgeMaxDelay            = 5 * time.Minute
+	defaultPostPurgePropagationInterval = 5 * time.Second
+	defaultPostPurgePropagationTimeout  = 5 * time.Minute
+	defaultPostPurgeConcurrency         = 10
+	defaultPostPurgeRequestTimeout      = 10 * time.Second
+	defaultPostPurgeDeadline            = 5 * time.Minute
+
+	defaultHistoryPath = "akapurgo.db"
+
+	defaultApprovalsExpiration = 24 * time.Hour
+
+	defaultAuthJWTHeader           = "Authorization"
+	defaultAuthJWTUserClaim        = "sub"
+	defaultAuthJWKSRefreshInterval = 1 * time.Hour
+
+	defaultAuthOIDCUserClaim       = "email"
+	defaultAuthOIDCSessionDuration = 8 * time.Hour
+	defaultAuthOIDCCookieName      = "akapurgo_session"
+)
+
+var (
+	defaultAuthOIDCScopes = []string{"openid", "email", "profile"}
+)
+
+// SetDefaults fills the unset fields of the configuration with their default values
+func SetDefaults(config *v1alpha1.ConfigSpec) {
+	if config.Server.ListenAddress == "" {
+		config.Server.ListenAddress = defaultListenAddress
+	}
+
+	if config.Akamai.RequestTimeout == 0 {
+		config.Akamai.RequestTimeout = defaultAkamaiRequestTimeout
+	}
+
+	if config.Akamai.Retry.MaxAttempts == 0 {
+		config.Akamai.Retry.MaxAttempts = defaultAkamaiRetryMaxAttempts
+	}
+
+	if config.Akamai.Retry.InitialBackoff == 0 {
+		config.Akamai.Retry.InitialBackoff = defaultAkamaiRetryInitialBackoff
+	}
+
+	if config.Akamai.Retry.MaxBackoff == 0 {
+		config.Akamai.Retry.MaxBackoff = defaultAkamaiRetryMaxBackoff
+	}
+
+	if config.RateLimit.Mode == "" {
+		config.RateLimit.Mode = defaultRateLimitMode
+	}
+
+	if config.RateLimit.QueueTimeout == 0 {
+		config.RateLimit.QueueTimeout = defaultRateLimitQueueTimeout
+	}
+
+	if config.Jobs.Retention == 0 {
+		config.Jobs.Retention = defaultJobsRetention
+	}
+
+	if config.Auth.JWT.Header == "" {
+		config.Auth.JWT.Header = defaultAuthJWTHeader
+	}
+
+	if config.Auth.JWT.UserClaim == "" {
+		config.Auth.JWT.UserClaim = defaultAuthJWTUserClaim
+	}
+
+	if config.Auth.JWT.JWKSRefreshInterval == 0 {
+		config.Auth.JWT.JWKSRefreshInterval = defaultAuthJWKSRefreshInterval
+	}
+
+	if config.Auth.OIDC.UserClaim == "" {
+		config.Auth.OIDC.UserClaim = defaultAuthOIDCUserClaim
+	}
+
+	if len(config.Auth.OIDC.Scopes) == 0 {
+		config.Auth.OIDC.Scopes = defaultAuthOIDCScopes
+	}
+
+	if config.Auth.OIDC.SessionDuration == 0 {
+		config.Auth.OIDC.SessionDuration = defaultAuthOIDCSessionDuration
+	}
+
+	if config.Auth.OIDC.CookieName == "" {
+		config.Auth.OIDC.CookieName = defaultAuthOIDCCookieName
+	}
+
+	// A delay of 0 is kept, to send the requests right after the purge
+	if config.PostPurgeRequest.Delay == nil {
+		delay := defaultPostPurgeDelay
+		config.PostPurgeRequest.Delay = &delay
+	}
+
+	if config.PostPurgeRequest.MaxDelay == 0 {
+		config.PostPurgeRequest.MaxDelay = defaultPostPurgeMaxDelay
+	}
+
+	if config.PostPurgeRequest.Propagation.Interval == 0 {
+		config.PostPurgeRequest.Propagation.Interval = defaultPostPurgePropagationInterval
+	}
+
+	if config.PostPurgeRequest.Propagation.Timeout == 0 {
+		config.PostPurgeRequest.Propagation.Timeout = defaultPostPurgePropagationTimeout
+	}
+
+	if config.PostPurgeRequest.Concurrency == 0 {
+		config.PostPurgeRequest.Concurrency = defaultPostPurgeConcurrency
+	}
+
+	if config.PostPurgeRequest.RequestTimeout == 0 {
+		config.PostPurgeRequest.RequestTimeout = defaultPostPurgeRequestTimeout
+	}
+
+	if config.PostPurgeRequest.Deadline == 0 {
+		config.PostPurgeRequest.Deadline = defaultPostPurgeDeadline
+	}
+
+	if config.History.Path == "" {
+		config.History.Path = defaultHistoryPath
+	}
+
+	if config.Approvals.Expiration == 0 {
+		config.Approvals.Expiration = defaultApprovalsExpiration
+	}
+
+	if config.Secrets.RefreshInterval == 0 {
+		config.Secrets.RefreshInterval = defaultSecretsRefreshInterval
+	}
+
+	if config.Secrets.Timeout == 0 {
+		config.Secrets.Timeout = defaultSecretsTimeout
+	}
+}
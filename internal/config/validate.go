@@ -0,0 +1,430 @@
+package config
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/policy"
+	"akapurgo/internal/validation"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	RateLimitModes = []string{"queue", "reject"}
+	PolicyEffects  = []string{policy.EffectAllow, policy.EffectDeny}
+
+	// AccessLogsFieldPrefixes are the sources the access logs fields can be read from
+	AccessLogsFieldPrefixes = []string{"REQUEST:", "REQUEST_HEADER:", "RESPONSE:", "RESPONSE_HEADER:"}
+
+	// kindNames describe the values expected by the fields of the configuration
+	kindNames = map[reflect.Kind]string{
+		reflect.Bool:    "a boolean",
+		reflect.Int:     "an integer",
+		reflect.Float64: "a number",
+		reflect.String:  "a string",
+	}
+
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// Error lists every problem found in a configuration, with the YAML path of the wrong fields
+type Error struct {
+	Problems []v1alpha1.ValidationError
+}
+
+func (e *Error) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		problems = append(problems, problem.Field+": "+problem.Message)
+	}
+
+	return "invalid configuration: " + strings.Join(problems, "; ")
+}
+
+// decodeStrict decodes the YAML document into the configuration. Unknown fields and values of the wrong type
+// are reported with their YAML path, along with the lines of all the paths found in the document
+func decodeStrict(bytes []byte) (config v1alpha1.ConfigSpec, lines map[string]int, errs []v1alpha1.ValidationError, err error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(bytes, &document); err != nil {
+		return config, nil, nil, err
+	}
+
+	lines = map[string]int{}
+	if len(document.Content) == 0 {
+		return config, lines, nil, nil
+	}
+
+	// Values of the wrong type are reported by checkNode, the other fields are still decoded
+	errs = checkNode(document.Content[0], reflect.TypeOf(config), "", lines)
+	if err := document.Content[0].Decode(&config); err != nil {
+		var typeError *yaml.TypeError
+		if !errors.As(err, &typeError) {
+			return config, lines, errs, err
+		}
+	}
+
+	return config, lines, errs, nil
+}
+
+// checkNode walks the YAML node along with the type it is decoded into
+func checkNode(node *yaml.Node, nodeType reflect.Type, nodePath string, lines map[string]int) (errs []v1alpha1.ValidationError) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return nil
+	}
+	for nodeType.Kind() == reflect.Pointer {
+		nodeType = nodeType.Elem()
+	}
+
+	invalid := func(message string) []v1alpha1.ValidationError {
+		return []v1alpha1.ValidationError{{
+			Field:   nodePath,
+			Value:   node.Value,
+			Message: fmt.Sprintf("%s (line %d)", message, node.Line),
+		}}
+	}
+
+	switch {
+	case nodeType == durationType || nodeType == timeType:
+		if err := node.Decode(reflect.New(nodeType).Interface()); err != nil {
+			return invalid(map[reflect.Type]string{durationType: "must be a duration", timeType: "must be a timestamp"}[nodeType])
+		}
+
+	case nodeType.Kind() == reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return invalid("must be a mapping")
+		}
+
+		fields := map[string]reflect.Type{}
+		for index := 0; index < nodeType.NumField(); index++ {
+			name, _, _ := strings.Cut(nodeType.Field(index).Tag.Get("yaml"), ",")
+			fields[name] = nodeType.Field(index).Type
+		}
+
+		for index := 0; index+1 < len(node.Content); index += 2 {
+			key, value := node.Content[index], node.Content[index+1]
+			keyPath := joinPath(nodePath, key.Value)
+			lines[keyPath] = key.Line
+
+			fieldType, found := fields[key.Value]
+			if !found {
+				errs = append(errs, v1alpha1.ValidationError{
+					Field:   keyPath,
+					Message: fmt.Sprintf("unknown field (line %d)", key.Line),
+				})
+				continue
+			}
+			errs = append(errs, checkNode(value, fieldType, keyPath, lines)...)
+		}
+
+	case nodeType.Kind() == reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return invalid("must be a mapping")
+		}
+
+		for index := 0; index+1 < len(node.Content); index += 2 {
+			keyPath := joinPath(nodePath, node.Content[index].Value)
+			lines[keyPath] = node.Content[index].Line
+			errs = append(errs, checkNode(node.Content[index+1], nodeType.Elem(), keyPath, lines)...)
+		}
+
+	case nodeType.Kind() == reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return invalid("must be a list")
+		}
+
+		for index, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", nodePath, index)
+			lines[itemPath] = item.Line
+			errs = append(errs, checkNode(item, nodeType.Elem(), itemPath, lines)...)
+		}
+
+	case nodeType.Kind() == reflect.Interface:
+		return nil
+
+	default:
+		if node.Kind != yaml.ScalarNode {
+			return invalid("must be a single value")
+		}
+		if err := node.Decode(reflect.New(nodeType).Interface()); err != nil {
+			return invalid("must be " + kindNames[nodeType.Kind()])
+		}
+	}
+
+	return errs
+}
+
+func joinPath(parent string, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// checker collects the problems found by the semantic checks
+type checker struct {
+	errs []v1alpha1.ValidationError
+}
+
+func (c *checker) add(field string, value string, message string, args ...interface{}) {
+	c.errs = append(c.errs, v1alpha1.ValidationError{
+		Field:   field,
+		Value:   value,
+		Message: fmt.Sprintf(message, args...),
+	})
+}
+
+func (c *checker) oneOf(field string, value string, allowed []string) {
+	if value != "" && !slices.Contains(allowed, value) {
+		c.add(field, value, "must be one of: %s", strings.Join(allowed, ", "))
+	}
+}
+
+func (c *checker) allOf(field string, values []string, allowed []string) {
+	for index, value := range values {
+		c.oneOf(fmt.Sprintf("%s[%d]", field, index), value, allowed)
+	}
+}
+
+func (c *checker) nonNegative(field string, value time.Duration) {
+	if value < 0 {
+		c.add(field, value.String(), "must not be negative")
+	}
+}
+
+func (c *checker) globs(field string, patterns []string) {
+	for index, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			c.add(fmt.Sprintf("%s[%d]", field, index), pattern, "must be a valid glob")
+		}
+	}
+}
+
+func (c *checker) url(field string, value string) {
+	parsedURL, err := url.Parse(value)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		c.add(field, value, "must be an http or https URL")
+	}
+}
+
+// credentials checks a set of Akamai credentials, which are set inline or loaded from an edgerc file
+func (c *checker) credentials(field string, host string, clientSecret string, clientToken string, accessToken string, edgerc string) {
+	if edgerc != "" {
+		return
+	}
+
+	values := []string{host, clientSecret, clientToken, accessToken}
+	for index, name := range []string{"host", "client_secret", "client_token", "access_token"} {
+		if values[index] == "" {
+			c.add(joinPath(field, name), "", "is required, unless edgerc is set")
+		}
+	}
+
+	if host != "" {
+		c.url(joinPath(field, "host"), host)
+	}
+}
+
+// Validate runs the semantic checks of the configuration, before the default values are set.
+// One error is returned per wrong field, with its YAML path
+func Validate(config *v1alpha1.ConfigSpec) []v1alpha1.ValidationError {
+	c := &checker{}
+
+	// Server
+	if address := config.Server.ListenAddress; address != "" {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			c.add("server.listen_address", address, "must be a host:port address")
+		}
+	}
+	if config.Server.Config.ReadBufferSize < 0 {
+		c.add("server.config.read_buffer_size", fmt.Sprint(config.Server.Config.ReadBufferSize), "must not be negative")
+	}
+
+	// Akamai accounts
+	akamaiConfig := config.Akamai
+	c.credentials("akamai", akamaiConfig.Host, akamaiConfig.ClientSecret, akamaiConfig.ClientToken,
+		akamaiConfig.AccessToken, akamaiConfig.Edgerc)
+
+	names := map[string]bool{}
+	for index, account := range akamaiConfig.Accounts {
+		field := fmt.Sprintf("akamai.accounts[%d]", index)
+
+		switch {
+		case account.Name == "":
+			c.add(field+".name", "", "is required")
+		case account.Name == "default":
+			c.add(field+".name", account.Name, "is reserved for the credentials set directly under akamai")
+		case names[account.Name]:
+			c.add(field+".name", account.Name, "must be unique")
+		}
+		names[account.Name] = true
+
+		// Accounts without their own credentials use the default ones
+		if account.ClientToken != "" || account.Edgerc != "" {
+			c.credentials(field, account.Host, account.ClientSecret, account.ClientToken, account.AccessToken, account.Edgerc)
+		}
+		c.globs(field+".hostnames", account.Hostnames)
+	}
+
+	c.nonNegative("akamai.request_timeout", akamaiConfig.RequestTimeout)
+	if akamaiConfig.Retry.MaxAttempts < 0 {
+		c.add("akamai.retry.max_attempts", fmt.Sprint(akamaiConfig.Retry.MaxAttempts), "must not be negative")
+	}
+	c.nonNegative("akamai.retry.initial_backoff", akamaiConfig.Retry.InitialBackoff)
+	c.nonNegative("akamai.retry.max_backoff", akamaiConfig.Retry.MaxBackoff)
+	if akamaiConfig.Retry.MaxBackoff > 0 && akamaiConfig.Retry.InitialBackoff > akamaiConfig.Retry.MaxBackoff {
+		c.add("akamai.retry.initial_backoff", akamaiConfig.Retry.InitialBackoff.String(), "must not exceed max_backoff")
+	}
+
+	c.nonNegative("secrets.refresh_interval", config.Secrets.RefreshInterval)
+	c.nonNegative("secrets.timeout", config.Secrets.Timeout)
+
+	// Rate limiting and jobs
+	c.oneOf("rate_limit.mode", config.RateLimit.Mode, RateLimitModes)
+	c.nonNegative("rate_limit.queue_timeout", config.RateLimit.QueueTimeout)
+	buckets := []v1alpha1.RateLimitBucket{config.RateLimit.URLs, config.RateLimit.CacheTags, config.RateLimit.CPCodes}
+	for index, name := range []string{"urls", "cache_tags", "cpcodes"} {
+		bucket := buckets[index]
+		if bucket.ObjectsPerSecond < 0 {
+			c.add("rate_limit."+name+".objects_per_second", fmt.Sprint(bucket.ObjectsPerSecond), "must not be negative")
+		}
+		if bucket.Burst < 0 {
+			c.add("rate_limit."+name+".burst", fmt.Sprint(bucket.Burst), "must not be negative")
+		}
+	}
+
+	c.nonNegative("jobs.retention", config.Jobs.Retention)
+
+	postPurge := config.PostPurgeRequest
+	if postPurge.Delay != nil {
+		c.nonNegative("post_purge_request.delay", *postPurge.Delay)
+	}
+	c.nonNegative("post_purge_request.max_delay", postPurge.MaxDelay)
+	c.nonNegative("post_purge_request.propagation.interval", postPurge.Propagation.Interval)
+	c.nonNegative("post_purge_request.propagation.timeout", postPurge.Propagation.Timeout)
+	if postPurge.Concurrency < 0 {
+		c.add("post_purge_request.concurrency", fmt.Sprint(postPurge.Concurrency), "must not be negative")
+	}
+	c.nonNegative("post_purge_request.request_timeout", postPurge.RequestTimeout)
+	c.nonNegative("post_purge_request.deadline", postPurge.Deadline)
+
+	// Authorization
+	for index, rule := range config.Policies.Rules {
+		field := fmt.Sprintf("policies.rules[%d]", index)
+
+		c.oneOf(field+".effect", rule.Effect, PolicyEffects)
+		c.allOf(field+".action_types", rule.ActionTypes, validation.ActionTypes)
+		c.allOf(field+".environments", rule.Environments, validation.Environments)
+		c.globs(field+".hostnames", rule.Hostnames)
+		c.globs(field+".tag_patterns", rule.TagPatterns)
+		for cpCodeIndex, cpCode := range rule.CPCodes {
+			if cpCode <= 0 {
+				c.add(fmt.Sprintf("%s.cpcodes[%d]", field, cpCodeIndex), fmt.Sprint(cpCode), "must be a positive number")
+			}
+		}
+	}
+
+	if config.Approvals.Enabled && !config.History.Enabled {
+		c.add("approvals.enabled", "true", "requires history.enabled, as pending purges are kept in the history")
+	}
+	if config.Approvals.Enabled && !config.Auth.Required {
+		c.add("approvals.enabled", "true", "requires auth.required, as requesters and reviewers must be told apart")
+	}
+	for index, rule := range config.Approvals.Rules {
+		field := fmt.Sprintf("approvals.rules[%d]", index)
+
+		c.allOf(field+".action_types", rule.ActionTypes, validation.ActionTypes)
+		c.allOf(field+".environments", rule.Environments, validation.Environments)
+		if rule.MaxPaths < 0 {
+			c.add(field+".max_paths", fmt.Sprint(rule.MaxPaths), "must not be negative")
+		}
+	}
+	c.nonNegative("approvals.expiration", config.Approvals.Expiration)
+
+	for index, team := range config.CPCodes.Teams {
+		for cpCodeIndex, cpCode := range team.CPCodes {
+			if cpCode <= 0 {
+				c.add(fmt.Sprintf("cpcodes.teams[%d].cpcodes[%d]", index, cpCodeIndex), fmt.Sprint(cpCode), "must be a positive number")
+			}
+		}
+	}
+
+	// Authentication
+	authConfig := config.Auth
+	if authConfig.Required && !authConfig.JWT.Enabled && !authConfig.APIKeys.Enabled && !authConfig.OIDC.Enabled {
+		c.add("auth.required", "true", "requires one of auth.jwt, auth.api_keys or auth.oidc to be enabled")
+	}
+
+	if authConfig.JWT.Enabled {
+		if authConfig.JWT.PublicKeyFile == "" && authConfig.JWT.JWKSFile == "" && authConfig.JWT.JWKSURL == "" {
+			c.add("auth.jwt", "", "one of public_key_file, jwks_file or jwks_url is required")
+		}
+		if authConfig.JWT.JWKSURL != "" {
+			c.url("auth.jwt.jwks_url", authConfig.JWT.JWKSURL)
+		}
+		c.nonNegative("auth.jwt.jwks_refresh_interval", authConfig.JWT.JWKSRefreshInterval)
+		c.nonNegative("auth.jwt.leeway", authConfig.JWT.Leeway)
+	}
+
+	for index, key := range authConfig.APIKeys.Keys {
+		field := fmt.Sprintf("auth.api_keys.keys[%d]", index)
+
+		if key.Name == "" {
+			c.add(field+".name", "", "is required")
+		}
+		hash := strings.TrimPrefix(key.Hash, "sha256:")
+		if hashBytes, err := hex.DecodeString(hash); err != nil || len(hashBytes) != sha256.Size {
+			c.add(field+".hash", key.Hash, "must be a SHA-256 hash in hex format (sha256:<hex>)")
+		}
+	}
+
+	if authConfig.OIDC.Enabled {
+		if authConfig.OIDC.Issuer == "" {
+			c.add("auth.oidc.issuer", "", "is required")
+		}
+		if authConfig.OIDC.ClientID == "" {
+			c.add("auth.oidc.client_id", "", "is required")
+		}
+		if authConfig.OIDC.RedirectURL == "" {
+			c.add("auth.oidc.redirect_url", "", "is required")
+		}
+		if authConfig.OIDC.Issuer != "" {
+			c.url("auth.oidc.issuer", authConfig.OIDC.Issuer)
+		}
+		if authConfig.OIDC.RedirectURL != "" {
+			c.url("auth.oidc.redirect_url", authConfig.OIDC.RedirectURL)
+		}
+		c.nonNegative("auth.oidc.session_duration", authConfig.OIDC.SessionDuration)
+	}
+
+	// Logs
+	if config.Logs.JwtUser.Enabled {
+		if config.Logs.JwtUser.Header == "" {
+			c.add("logs.jwt_user.header", "", "is required")
+		}
+		if config.Logs.JwtUser.JwtField == "" {
+			c.add("logs.jwt_user.jwt_field", "", "is required")
+		}
+	}
+	for index, field := range config.Logs.AccessLogsFields {
+		if !slices.ContainsFunc(AccessLogsFieldPrefixes, func(prefix string) bool { return strings.HasPrefix(field, prefix) }) {
+			c.add(fmt.Sprintf("logs.access_logs_fields[%d]", index), field, "must start with one of: %s",
+				strings.Join(AccessLogsFieldPrefixes, ", "))
+		}
+	}
+
+	return c.errs
+}
@@ -0,0 +1,95 @@
+package jobs
+
+import (
+	"akapurgo/api/v1alpha1"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Store keeps the purge jobs in memory. Finished jobs are removed once the retention time has passed
+type Store struct {
+	mutex     sync.RWMutex
+	jobs      map[string]*v1alpha1.PurgeJob
+	retention time.Duration
+}
+
+// NewStore creates an empty job store
+func NewStore(retention time.Duration) *Store {
+	return &Store{
+		jobs:      map[string]*v1alpha1.PurgeJob{},
+		retention: retention,
+	}
+}
+
+// Create stores a new queued job for the given request and returns a copy of it
+func (s *Store) Create(req v1alpha1.PurgeRequest) (job v1alpha1.PurgeJob, err error) {
+	id, err := newID()
+	if err != nil {
+		return job, err
+	}
+
+	now := time.Now()
+	job = v1alpha1.PurgeJob{
+		ID:        id,
+		Stage:     v1alpha1.JobStageQueued,
+		Request:   req,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.removeExpired(now)
+	s.jobs[id] = &job
+	return job, nil
+}
+
+// Get returns a copy of the job with the given ID
+func (s *Store) Get(id string) (job v1alpha1.PurgeJob, ok bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	stored, ok := s.jobs[id]
+	if !ok {
+		return job, false
+	}
+
+	return *stored, true
+}
+
+// Update applies the given function to the job with the given ID while holding the lock
+func (s *Store) Update(id string, update func(job *v1alpha1.PurgeJob)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+
+	update(job)
+	job.UpdatedAt = time.Now()
+}
+
+// removeExpired removes the finished jobs older than the retention time. The lock must be held by the caller
+func (s *Store) removeExpired(now time.Time) {
+	for id, job := range s.jobs {
+		finished := job.Stage == v1alpha1.JobStageDone || job.Stage == v1alpha1.JobStageFailed
+		if finished && now.Sub(job.UpdatedAt) > s.retention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// newID returns a random identifier for a job
+func newID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
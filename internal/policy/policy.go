@@ -0,0 +1,241 @@
+package policy
+
+import (
+	"akapurgo/api/v1alpha1"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+
+	// Wildcard matches any authenticated user or group
+	Wildcard = "*"
+)
+
+// Decision is the result of evaluating the policies for a purge request.
+// Rule names the rules that blocked the request, and Path the first path they blocked, if any
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Evaluate decides whether the given identity may run the purge request.
+// Deny rules applying to the request block it right away. Otherwise, every path must be allowed
+// by at least one allow rule of the identity, which also allows the action and the environment
+func Evaluate(ctx v1alpha1.Context, identity v1alpha1.Identity, req v1alpha1.PurgeRequest) Decision {
+	policies := ctx.Config().Policies
+	if !policies.Enabled {
+		return Decision{Allowed: true, Reason: "policies are disabled"}
+	}
+
+	if identity.User == "" {
+		return Decision{Reason: "policies require an authenticated user"}
+	}
+
+	// Select the rules that apply to the identity
+	var allowRules, denyRules []v1alpha1.PolicyRule
+	for _, rule := range policies.Rules {
+		if !appliesTo(rule, identity) {
+			continue
+		}
+
+		if rule.Effect == EffectDeny {
+			denyRules = append(denyRules, rule)
+		} else {
+			allowRules = append(allowRules, rule)
+		}
+	}
+
+	for _, rule := range denyRules {
+		if err := checkRequest(rule, req); err != nil {
+			continue
+		}
+		for _, entry := range req.Paths {
+			if err := checkPath(rule, req.PurgeType, entry); err == nil {
+				return Decision{
+					Rule:   rule.Name,
+					Path:   entry,
+					Reason: fmt.Sprintf("denied by rule '%s'", rule.Name),
+				}
+			}
+		}
+	}
+
+	if len(allowRules) == 0 {
+		return Decision{Reason: fmt.Sprintf("no policy rule applies to user '%s'", identity.User)}
+	}
+
+	for _, entry := range req.Paths {
+		var reasons []string
+		var names []string
+		allowed := false
+
+		for _, rule := range allowRules {
+			err := checkRequest(rule, req)
+			if err == nil {
+				err = checkPurgeType(rule, req.PurgeType)
+			}
+			if err == nil {
+				err = checkPath(rule, req.PurgeType, entry)
+			}
+			if err == nil {
+				allowed = true
+				break
+			}
+			names = append(names, rule.Name)
+			reasons = append(reasons, fmt.Sprintf("rule '%s': %v", rule.Name, err))
+		}
+
+		if !allowed {
+			return Decision{
+				Rule:   strings.Join(names, ","),
+				Path:   entry,
+				Reason: strings.Join(reasons, "; "),
+			}
+		}
+	}
+
+	return Decision{Allowed: true, Reason: "allowed by policies"}
+}
+
+// appliesTo returns whether the rule applies to the given identity, by user or by group
+func appliesTo(rule v1alpha1.PolicyRule, identity v1alpha1.Identity) bool {
+	if slices.Contains(rule.Users, Wildcard) || slices.Contains(rule.Users, identity.User) {
+		return true
+	}
+
+	for _, group := range identity.Groups {
+		if slices.Contains(rule.Groups, group) {
+			return true
+		}
+	}
+
+	return slices.Contains(rule.Groups, Wildcard) && len(identity.Groups) > 0
+}
+
+// checkRequest checks the action and the environment of the request against the rule.
+// Empty lists in the rule allow any value
+func checkRequest(rule v1alpha1.PolicyRule, req v1alpha1.PurgeRequest) error {
+	if len(rule.ActionTypes) > 0 && !slices.Contains(rule.ActionTypes, req.ActionType) {
+		return fmt.Errorf("action '%s' not allowed", req.ActionType)
+	}
+
+	if len(rule.Environments) > 0 && !slices.Contains(rule.Environments, req.Environment) {
+		return fmt.Errorf("environment '%s' not allowed", req.Environment)
+	}
+
+	return nil
+}
+
+// checkPurgeType checks the purge type of the request against an allow rule. Rules limiting the paths
+// of some purge types do not allow the others, so that a rule limited to some hostnames does not allow
+// to purge any CP code. Deny rules are not checked, as they block every purge type they do not limit
+func checkPurgeType(rule v1alpha1.PolicyRule, purgeType string) error {
+	limits := map[string]bool{
+		"urls":       len(rule.Hostnames) > 0 || len(rule.URLPrefixes) > 0,
+		"cache-tags": len(rule.TagPatterns) > 0,
+		"cpcodes":    len(rule.CPCodes) > 0,
+	}
+
+	if !limits[purgeType] && (limits["urls"] || limits["cache-tags"] || limits["cpcodes"]) {
+		return fmt.Errorf("purge type '%s' not allowed, the rule only allows some %s", purgeType,
+			strings.Join(limitedTypes(limits), ", "))
+	}
+
+	return nil
+}
+
+// limitedTypes returns the purge types limited by a rule, in a stable order
+func limitedTypes(limits map[string]bool) (purgeTypes []string) {
+	for _, purgeType := range []string{"urls", "cache-tags", "cpcodes"} {
+		if limits[purgeType] {
+			purgeTypes = append(purgeTypes, purgeType)
+		}
+	}
+
+	return purgeTypes
+}
+
+// checkPath checks a single entry of the request against the rule, depending on the purge type.
+// URLs are checked against the hostnames and URL prefixes, cache tags against the tag patterns,
+// and CP codes against the CP codes. Empty lists in the rule allow any value
+func checkPath(rule v1alpha1.PolicyRule, purgeType, entry string) error {
+	switch purgeType {
+	case "urls":
+		if len(rule.Hostnames) > 0 {
+			parsedURL, err := url.Parse(entry)
+			if err != nil {
+				return fmt.Errorf("invalid URL '%s'", entry)
+			}
+			if !matchesHostname(rule.Hostnames, parsedURL.Hostname()) {
+				return fmt.Errorf("hostname '%s' not allowed", parsedURL.Hostname())
+			}
+		}
+
+		if len(rule.URLPrefixes) > 0 && !slices.ContainsFunc(rule.URLPrefixes, func(prefix string) bool {
+			return strings.HasPrefix(normalizeURL(entry), normalizeURL(prefix))
+		}) {
+			return fmt.Errorf("URL '%s' does not match any allowed prefix", entry)
+		}
+
+	case "cache-tags":
+		if len(rule.TagPatterns) > 0 && !matchesAny(rule.TagPatterns, entry) {
+			return fmt.Errorf("tag '%s' does not match any allowed pattern", entry)
+		}
+
+	case "cpcodes":
+		if len(rule.CPCodes) > 0 {
+			cpCode, err := strconv.Atoi(entry)
+			if err != nil {
+				return fmt.Errorf("invalid CP code '%s'", entry)
+			}
+			if !slices.Contains(rule.CPCodes, cpCode) {
+				return fmt.Errorf("CP code %d not allowed", cpCode)
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchesHostname returns whether the hostname matches any of the glob patterns, ignoring the case
+// as hostnames are case-insensitive
+func matchesHostname(patterns []string, hostname string) bool {
+	hostname = strings.ToLower(hostname)
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, err := path.Match(strings.ToLower(pattern), hostname)
+		return err == nil && matched
+	})
+}
+
+// normalizeURL lowercases the scheme and the host of a URL, or of a URL prefix, leaving the rest untouched
+// as paths are case-sensitive
+func normalizeURL(rawURL string) string {
+	scheme, rest, found := strings.Cut(rawURL, "://")
+	if !found {
+		return rawURL
+	}
+
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+
+	return strings.ToLower(scheme) + "://" + strings.ToLower(rest[:end]) + rest[end:]
+}
+
+// matchesAny returns whether the value matches any of the glob patterns (e.g. *.example.com)
+func matchesAny(patterns []string, value string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		matched, err := path.Match(pattern, value)
+		return err == nil && matched
+	})
+}
@@ -0,0 +1,67 @@
+package policy
+
+import (
+	"akapurgo/api/v1alpha1"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestEvaluatePurgeTypes(t *testing.T) {
+	hostnames := v1alpha1.PolicyRule{Name: "hostnames", Users: []string{"alice"}, Hostnames: []string{"brand-a.example.com"}}
+	cpCodes := v1alpha1.PolicyRule{Name: "cpcodes", Users: []string{"alice"}, CPCodes: []int{123456}}
+	prefixes := v1alpha1.PolicyRule{Name: "prefixes", Users: []string{"alice"},
+		URLPrefixes: []string{"https://Brand-A.example.com/Assets/"}}
+	unlimited := v1alpha1.PolicyRule{Name: "unlimited", Users: []string{"alice"}}
+	denyHostnames := v1alpha1.PolicyRule{Name: "deny", Effect: EffectDeny, Users: []string{"*"},
+		Hostnames: []string{"secret.example.com"}}
+
+	tests := []struct {
+		name      string
+		rules     []v1alpha1.PolicyRule
+		purgeType string
+		paths     []string
+		allowed   bool
+	}{
+		{"hostnames rule allows its URLs", []v1alpha1.PolicyRule{hostnames}, "urls",
+			[]string{"https://brand-a.example.com/a"}, true},
+		{"hostnames rule denies CP codes", []v1alpha1.PolicyRule{hostnames}, "cpcodes", []string{"123456"}, false},
+		{"hostnames rule denies cache tags", []v1alpha1.PolicyRule{hostnames}, "cache-tags", []string{"tag"}, false},
+		{"cpcodes rule allows its CP codes", []v1alpha1.PolicyRule{cpCodes}, "cpcodes", []string{"123456"}, true},
+		{"cpcodes rule denies other CP codes", []v1alpha1.PolicyRule{cpCodes}, "cpcodes",
+			[]string{"123456", "654321"}, false},
+		{"cpcodes rule denies URLs", []v1alpha1.PolicyRule{cpCodes}, "urls", []string{"https://a.example.com/"}, false},
+		{"CP codes allowed by another rule", []v1alpha1.PolicyRule{hostnames, cpCodes}, "cpcodes",
+			[]string{"123456"}, true},
+		{"unlimited rule allows CP codes", []v1alpha1.PolicyRule{unlimited}, "cpcodes", []string{"654321"}, true},
+		{"hostnames rule ignores the case", []v1alpha1.PolicyRule{hostnames}, "urls",
+			[]string{"https://Brand-A.Example.COM/a"}, true},
+		{"deny rule on hostnames ignores the case", []v1alpha1.PolicyRule{unlimited, denyHostnames}, "urls",
+			[]string{"https://SECRET.example.com/x"}, false},
+		{"URL prefixes ignore the case of the scheme and host", []v1alpha1.PolicyRule{prefixes}, "urls",
+			[]string{"HTTPS://brand-a.EXAMPLE.com/Assets/logo.png"}, true},
+		{"URL prefixes keep the case of the path", []v1alpha1.PolicyRule{prefixes}, "urls",
+			[]string{"https://brand-a.example.com/assets/logo.png"}, false},
+		{"deny rule on hostnames blocks CP codes", []v1alpha1.PolicyRule{unlimited, denyHostnames}, "cpcodes",
+			[]string{"654321"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := &v1alpha1.ConfigSpec{}
+			config.Policies.Enabled = true
+			config.Policies.Rules = test.rules
+			ctx := v1alpha1.NewContext(config, zap.NewNop().Sugar())
+
+			decision := Evaluate(ctx, v1alpha1.Identity{User: "alice"}, v1alpha1.PurgeRequest{
+				PurgeType:   test.purgeType,
+				ActionType:  "invalidate",
+				Environment: "production",
+				Paths:       test.paths,
+			})
+			if decision.Allowed != test.allowed {
+				t.Errorf("expected allowed=%v, got %v: %s", test.allowed, decision.Allowed, decision.Reason)
+			}
+		})
+	}
+}
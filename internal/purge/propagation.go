@@ -0,0 +1,152 @@
+package purge
+
+import (
+	"akapurgo/api/v1alpha1"
+	"context"
+	"sync"
+	"time"
+)
+
+// validators identify the version of an object served by the edge
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// servedObject is the object served by the edge for a path: its validators, and the cache status and verdict
+// drawn from the Akamai debug headers of the response
+type servedObject struct {
+	validators  validators
+	cacheStatus string
+	verdict     string
+}
+
+// verifiable returns whether the object can be compared with the one served after the purge
+func (o servedObject) verifiable() bool {
+	return o.validators != (validators{}) || o.cacheStatus != ""
+}
+
+// propagatedFrom returns whether the object is not the one served before the purge: its validators changed,
+// or the edge fetched it from the origin, revalidated it, or cached it since the purge started.
+// Invalidated objects keep their validators when they did not change at the origin
+func (o servedObject) propagatedFrom(before servedObject) bool {
+	if o.validators != (validators{}) && o.validators != before.validators {
+		return true
+	}
+
+	switch o.verdict {
+	case v1alpha1.VerdictFetched, v1alpha1.VerdictRevalidated, v1alpha1.VerdictFreshHit:
+		return true
+	}
+
+	return false
+}
+
+// postPurgeDelay returns the time waited for the purge to propagate before the post-purge requests
+func postPurgeDelay(config v1alpha1.ConfigSpec, estimatedSeconds int) time.Duration {
+	postPurge := config.PostPurgeRequest
+	if postPurge.WaitForEstimate && estimatedSeconds > 0 {
+		return min(time.Duration(estimatedSeconds)*time.Second, postPurge.MaxDelay)
+	}
+
+	if postPurge.Delay == nil {
+		return 0
+	}
+
+	return *postPurge.Delay
+}
+
+// getServed requests the paths as the post-purge requests do, and returns the objects served, with the verdicts
+// for a purge started at purgedAt. Paths that can not be compared, or failing, are left out
+func getServed(ctx v1alpha1.Context, parent context.Context, paths []string,
+	purgedAt time.Time) map[string]servedObject {
+
+	var mutex sync.Mutex
+	served := map[string]servedObject{}
+
+	forEachPath(parent, paths, ctx.Config().PostPurgeRequest.Concurrency, func(path string) {
+		response, err := get(ctx, parent, path)
+		if err != nil {
+			ctx.Logger.Warnf("Failed to get validators of %s: %v\n", path, err)
+			return
+		}
+
+		var result v1alpha1.WarmedURL
+		verify(&result, response, purgedAt)
+		object := servedObject{
+			validators: validators{
+				etag:         response.Header.Get("ETag"),
+				lastModified: response.Header.Get("Last-Modified"),
+			},
+			cacheStatus: result.Cache,
+			verdict:     result.Verdict,
+		}
+		if !object.verifiable() {
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		served[path] = object
+	})
+
+	return served
+}
+
+// fetchBaseline returns the objects served before the purge
+func fetchBaseline(ctx v1alpha1.Context, paths []string) map[string]servedObject {
+	deadline, cancel := context.WithTimeout(context.Background(), ctx.Config().PostPurgeRequest.Propagation.Timeout)
+	defer cancel()
+
+	return getServed(ctx, deadline, paths, time.Now())
+}
+
+// waitForPropagation polls the paths until the edge serves other objects than before the purge started
+// at purgedAt, or until the timeout. Paths missing from the baseline are reported as unverified
+func waitForPropagation(ctx v1alpha1.Context, paths []string, baseline map[string]servedObject, purgedAt time.Time,
+	done func(done int)) (report v1alpha1.PropagationReport) {
+
+	config := ctx.Config().PostPurgeRequest.Propagation
+	start := time.Now()
+
+	deadline, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	var pending []string
+	report.Unverified = []string{}
+	for _, path := range paths {
+		if _, found := baseline[path]; found {
+			pending = append(pending, path)
+		} else {
+			report.Unverified = append(report.Unverified, path)
+		}
+	}
+
+	for len(pending) > 0 {
+		served := getServed(ctx, deadline, pending, purgedAt)
+
+		var unchanged []string
+		for _, path := range pending {
+			if object, found := served[path]; !found || !object.propagatedFrom(baseline[path]) {
+				unchanged = append(unchanged, path)
+				continue
+			}
+			report.Changed++
+		}
+		pending = unchanged
+		done(report.Changed)
+
+		if len(pending) == 0 || time.Now().Add(config.Interval).After(start.Add(config.Timeout)) {
+			break
+		}
+		time.Sleep(config.Interval)
+	}
+
+	report.Unchanged = append([]string{}, pending...)
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	ctx.Logger.Infof("purge-propagation,changed=%d,unchanged=%d,unverified=%d,duration=%s",
+		report.Changed, len(report.Unchanged), len(report.Unverified), report.Duration)
+
+	return report
+}
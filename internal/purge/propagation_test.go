@@ -0,0 +1,115 @@
+package purge
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/akamai"
+	"akapurgo/internal/config"
+	"akapurgo/internal/ratelimit"
+	"akapurgo/internal/secrets"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestWaitForPropagation(t *testing.T) {
+	// Before the purge, every object is a hit cached an hour ago. After it, the edge answers
+	// depending on the path, always with the same ETag as the origin content did not change
+	var purged atomic.Bool
+	edge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if !purged.Load() || r.URL.Path == "/stale" {
+			w.Header().Set("X-Cache", "TCP_HIT from a1.deploy.akamaitechnologies.com")
+			w.Header().Set("Age", "3600")
+			return
+		}
+
+		switch r.URL.Path {
+		case "/revalidated":
+			w.Header().Set("X-Cache", "TCP_REFRESH_HIT from a1.deploy.akamaitechnologies.com")
+		case "/fetched":
+			w.Header().Set("X-Cache", "TCP_MISS from a1.deploy.akamaitechnologies.com")
+		case "/cached":
+			w.Header().Set("X-Cache", "TCP_HIT from a1.deploy.akamaitechnologies.com")
+			w.Header().Set("Age", "0")
+		case "/changed":
+			w.Header().Set("ETag", `"v2"`)
+		}
+	}))
+	defer edge.Close()
+
+	configContent := &v1alpha1.ConfigSpec{}
+	config.SetDefaults(configContent)
+	configContent.PostPurgeRequest.Propagation.Interval = 10 * time.Millisecond
+	configContent.PostPurgeRequest.Propagation.Timeout = 200 * time.Millisecond
+	ctx := v1alpha1.NewContext(configContent, zap.NewNop().Sugar())
+
+	paths := []string{edge.URL + "/revalidated", edge.URL + "/fetched", edge.URL + "/cached", edge.URL + "/changed",
+		edge.URL + "/stale"}
+	baseline := fetchBaseline(ctx, paths)
+	purgedAt := time.Now()
+	purged.Store(true)
+
+	report := waitForPropagation(ctx, paths, baseline, purgedAt, func(int) {})
+
+	if report.Changed != 4 {
+		t.Errorf("expected 4 URLs serving new objects, got %d", report.Changed)
+	}
+	if len(report.Unchanged) != 1 || report.Unchanged[0] != edge.URL+"/stale" {
+		t.Errorf("expected only /stale unchanged, got %v", report.Unchanged)
+	}
+	if len(report.Unverified) != 0 {
+		t.Errorf("expected no unverified URL, got %v", report.Unverified)
+	}
+}
+
+func TestRunRateLimitedSendsNoRequest(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	configContent := &v1alpha1.ConfigSpec{}
+	configContent.Akamai.Host = server.URL
+	configContent.Akamai.ClientSecret = "secret"
+	configContent.Akamai.ClientToken = "client-token"
+	configContent.Akamai.AccessToken = "access-token"
+	configContent.RateLimit.Enabled = true
+	configContent.RateLimit.Mode = ratelimit.ModeReject
+	configContent.RateLimit.URLs = v1alpha1.RateLimitBucket{ObjectsPerSecond: 0.001, Burst: 1}
+	configContent.PostPurgeRequest.Enabled = true
+	configContent.PostPurgeRequest.Propagation.Enabled = true
+	config.SetDefaults(configContent)
+	ctx := v1alpha1.NewContext(configContent, zap.NewNop().Sugar())
+
+	credentials, err := akamai.NewCredentials(configContent, secrets.NewResolver(configContent.Secrets))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another purge already took the whole bucket
+	limiter := ratelimit.NewLimiter(ctx)
+	if err := limiter.Wait("urls", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewPurger(ctx, limiter, credentials).Run(v1alpha1.PurgeRequest{
+		PurgeType:        "urls",
+		ActionType:       "invalidate",
+		Environment:      "staging",
+		PostPurgeRequest: true,
+		Paths:            []string{server.URL + "/a", server.URL + "/b"},
+	}, nil)
+
+	if !errors.Is(err, ratelimit.ErrRateLimited) {
+		t.Errorf("expected the purge rate limited, got %v", err)
+	}
+	if requests.Load() != 0 {
+		t.Errorf("expected no request before the purge got through the rate limiter, got %d", requests.Load())
+	}
+}
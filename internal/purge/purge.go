@@ -0,0 +1,330 @@
+package purge
+
+import (
+	"akapurgo/api/v1alpha1"
+	"akapurgo/internal/akamai"
+	"akapurgo/internal/ratelimit"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxIdleConnsPerHost is the number of idle connections kept by host for the post-purge requests
+	maxIdleConnsPerHost = 100
+)
+
+var (
+	ErrInvalidRequest = errors.New("invalid purge request")
+
+	// sensitiveHeaders are the post-purge headers whose values are hidden in the purge plans
+	sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+	// httpClient is shared between all the purges as it is safe for concurrent use.
+	// Everything else related to a purge lives inside the scope of the purge itself
+	httpClient = newHTTPClient()
+)
+
+// newHTTPClient returns the client of the post-purge requests, keeping idle connections for all the workers
+// requesting the same hosts
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+
+	return &http.Client{Transport: transport}
+}
+
+// ProgressFunc is called every time a purge moves to another stage, or makes progress inside the current one
+type ProgressFunc func(stage string, done, total int)
+
+// Purger runs the purges against Akamai, from the calls to the Fast Purge API to the post-purge requests.
+// It is safe for concurrent use, as every purge keeps its own state
+type Purger struct {
+	ctx         v1alpha1.Context
+	limiter     *ratelimit.Limiter
+	credentials *akamai.Credentials
+}
+
+// NewPurger creates a purger. The rate limiter is shared by all the purges to respect the Akamai quotas,
+// and the credentials sign the calls of every account
+func NewPurger(ctx v1alpha1.Context, limiter *ratelimit.Limiter, credentials *akamai.Credentials) *Purger {
+	return &Purger{
+		ctx:         ctx,
+		limiter:     limiter,
+		credentials: credentials,
+	}
+}
+
+// Run purges the paths of an already validated request, reporting the progress of every stage.
+// A RateLimitError is returned when the purge is rejected by the rate limiter before reaching Akamai
+func (p *Purger) Run(req v1alpha1.PurgeRequest, progress ProgressFunc) (response v1alpha1.PurgeResponse, err error) {
+	ctx := p.ctx
+	if progress == nil {
+		progress = func(string, int, int) {}
+	}
+
+	account, purgeURL, batches, err := p.prepare(req)
+	if err != nil {
+		return response, err
+	}
+
+	// Send every batch to Akamai, keeping track of the paths that were successfully purged
+	var baseline map[string]servedObject
+	var purgedAt time.Time
+	var purgedPaths []string
+	var purgeBatches []v1alpha1.PurgeBatch
+	offset := 0
+	progress(v1alpha1.JobStageSubmitted, 0, len(batches))
+	for index, batch := range batches {
+
+		// Wait for the rate limiter before calling Akamai. When the first call is over the limit,
+		// the whole request is rejected. Later calls are reported as rate limited in their batch
+		err := p.limiter.Wait(req.PurgeType, len(batch))
+		if err != nil && index == 0 {
+			return response, err
+		}
+		if err != nil {
+			ctx.Logger.Warnf("Purge of batch %d/%d rejected: %v\n", index+1, len(batches), err)
+			purgeBatches = append(purgeBatches, v1alpha1.PurgeBatch{
+				Objects: len(batch),
+				AkamaiResponse: v1alpha1.AkamaiResponse{
+					HTTPStatus: http.StatusTooManyRequests,
+					Detail:     err.Error(),
+				},
+			})
+			offset += len(batch)
+			progress(v1alpha1.JobStageSubmitted, index+1, len(batches))
+			continue
+		}
+
+		// The objects served before the purge are compared with those served once it propagated.
+		// They are only requested once the purge got through the rate limiter
+		if index == 0 {
+			if p.polls(req) {
+				baseline = fetchBaseline(ctx, req.Paths)
+			}
+			purgedAt = time.Now()
+		}
+
+		akamaiResp, attempts, err := akamai.Purge(ctx, p.credentials, account.Name, purgeURL, batch)
+		if err != nil {
+			ctx.Logger.Errorf("Failed to purge batch %d/%d: %v\n", index+1, len(batches), err)
+			// Purges accepted by Akamai keep their status, even when the response could not be read
+			if !is2xx(akamaiResp.HTTPStatus) {
+				akamaiResp.HTTPStatus = http.StatusBadGateway
+			}
+			akamaiResp.Detail = err.Error()
+		}
+		purgeBatch := v1alpha1.PurgeBatch{
+			Objects:        len(batch),
+			Attempts:       attempts,
+			AkamaiResponse: akamaiResp,
+		}
+
+		if is2xx(akamaiResp.HTTPStatus) {
+			purgedPaths = append(purgedPaths, req.Paths[offset:offset+len(batch)]...)
+		}
+		offset += len(batch)
+
+		ctx.Logger.Infof(`akamai-response,batch=%d/%d,attempts=%d,purgeId='%s',supportId='%s',title='%s',detail='%s',status=%d`,
+			index+1, len(batches), attempts, akamaiResp.PurgeID, akamaiResp.SupportID, akamaiResp.Title, akamaiResp.Detail,
+			akamaiResp.HTTPStatus)
+		purgeBatches = append(purgeBatches, purgeBatch)
+		progress(v1alpha1.JobStageSubmitted, index+1, len(batches))
+	}
+
+	response = combinePurgeBatches(purgeBatches)
+	response.Account = account.Name
+
+	// Send a GET requests to purged URLs
+	if len(purgedPaths) > 0 && p.warms(req) {
+		progress(v1alpha1.JobStageWaiting, 0, 0)
+		time.Sleep(postPurgeDelay(*ctx.Config(), response.EstimatedSeconds)) // Wait before sending GET requests
+
+		// Wait until the edge stops serving the old objects
+		if baseline != nil {
+			progress(v1alpha1.JobStagePolling, 0, len(purgedPaths))
+			propagation := waitForPropagation(ctx, purgedPaths, baseline, purgedAt, func(done int) {
+				progress(v1alpha1.JobStagePolling, done, len(purgedPaths))
+			})
+			response.Propagation = &propagation
+			if len(propagation.Unchanged) > 0 {
+				response.Detail += fmt.Sprintf(", %d URLs still serve the old objects", len(propagation.Unchanged))
+			}
+		}
+
+		progress(v1alpha1.JobStageWarming, 0, len(purgedPaths))
+		warming := executePurgeRequest(ctx, purgedPaths, purgedAt, func(done int) {
+			progress(v1alpha1.JobStageWarming, done, len(purgedPaths))
+		})
+		response.Warming = &warming
+		if stale := warming.Verdicts[v1alpha1.VerdictStaleHit]; stale > 0 {
+			response.Detail += fmt.Sprintf(", %d URLs still served from the cache of before the purge", stale)
+		}
+	}
+
+	return response, nil
+}
+
+// Plan runs every step of the purge of an already validated request except the calls, returning the
+// Fast Purge calls and the warm-up requests that would be sent. Rate limits are not consumed
+func (p *Purger) Plan(req v1alpha1.PurgeRequest) (plan v1alpha1.PurgePlan, err error) {
+	account, purgeURL, batches, err := p.prepare(req)
+	if err != nil {
+		return plan, err
+	}
+
+	plan.DryRun = true
+	plan.Account = account.Name
+	plan.Calls = []v1alpha1.PlannedCall{}
+	for _, batch := range batches {
+		payloadBytes, err := akamai.Payload(batch)
+		if err != nil {
+			return plan, err
+		}
+
+		plan.Calls = append(plan.Calls, v1alpha1.PlannedCall{
+			Method:  http.MethodPost,
+			URL:     purgeURL,
+			Objects: len(batch),
+			Payload: payloadBytes,
+		})
+	}
+
+	// Warm-up requests are planned as if every batch was accepted
+	if p.warms(req) {
+		configContent := p.ctx.Config()
+		config := configContent.PostPurgeRequest
+		plan.PostPurge = &v1alpha1.PostPurgePlan{
+			Delay:           postPurgeDelay(*configContent, 0).String(),
+			PollPropagation: p.polls(req),
+			Requests:        []v1alpha1.PlannedRequest{},
+		}
+		if config.WaitForEstimate {
+			plan.PostPurge.Delay = fmt.Sprintf("estimatedSeconds, up to %s", config.MaxDelay)
+		}
+		for _, path := range req.Paths {
+			plan.PostPurge.Requests = append(plan.PostPurge.Requests, v1alpha1.PlannedRequest{
+				Method:  http.MethodGet,
+				URL:     path,
+				Headers: redactHeaders(requestHeaders(config.Headers)),
+			})
+		}
+	}
+
+	return plan, nil
+}
+
+// prepare resolves the Akamai account and the Fast Purge endpoint of the request, and splits its objects
+// into batches fitting in the body limit of Fast Purge
+func (p *Purger) prepare(req v1alpha1.PurgeRequest) (account v1alpha1.AkamaiAccount, purgeURL string,
+	batches [][]interface{}, err error) {
+
+	account, err = p.credentials.Resolve(req)
+	if err != nil {
+		return account, "", nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	// Determine the Akamai API URL and the objects to purge
+	purgeURL, err = akamai.PurgeURL(account.Host, req.PurgeType, req.ActionType, req.Environment)
+	if err != nil {
+		return account, "", nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	objects := make([]interface{}, 0, len(req.Paths))
+	if req.PurgeType == "cpcodes" {
+		cpCodes, err := ParseCPCodes(req.Paths)
+		if err != nil {
+			return account, "", nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+		for _, cpCode := range cpCodes {
+			objects = append(objects, cpCode)
+		}
+	} else {
+		for _, path := range req.Paths {
+			objects = append(objects, path)
+		}
+	}
+
+	// Split the objects so every call fits in the body limit of Fast Purge
+	batches, err = akamai.SplitObjects(objects, akamai.FastPurgeBodyLimit)
+	if err != nil {
+		return account, "", nil, fmt.Errorf("%w: failed to split the paths into batches: %v", ErrInvalidRequest, err)
+	}
+
+	return account, purgeURL, batches, nil
+}
+
+// warms returns whether the purged URLs of the request are requested again after the purge
+func (p *Purger) warms(req v1alpha1.PurgeRequest) bool {
+	return req.PurgeType == "urls" && req.PostPurgeRequest && p.ctx.Config().PostPurgeRequest.Enabled
+}
+
+// polls returns whether the post-purge requests wait for the edge to serve new objects
+func (p *Purger) polls(req v1alpha1.PurgeRequest) bool {
+	return p.warms(req) && p.ctx.Config().PostPurgeRequest.Propagation.Enabled
+}
+
+// redactHeaders returns the given headers, hiding the values of the ones carrying credentials
+func redactHeaders(headers map[string]string) map[string]string {
+	redacted := make(map[string]string, len(headers))
+	for key, value := range headers {
+		if slices.Contains(sensitiveHeaders, http.CanonicalHeaderKey(key)) {
+			value = "REDACTED"
+		}
+		redacted[key] = value
+	}
+
+	return redacted
+}
+
+// combinePurgeBatches merges the responses of all the batches into a single response.
+// The status is the one from Akamai when all the batches agree, or 207 when only some of them succeeded
+func combinePurgeBatches(batches []v1alpha1.PurgeBatch) (response v1alpha1.PurgeResponse) {
+	response.Batches = batches
+
+	succeeded := 0
+	for _, batch := range batches {
+		if is2xx(batch.HTTPStatus) {
+			succeeded++
+		} else if response.HTTPStatus == 0 {
+			response.HTTPStatus = batch.HTTPStatus
+		}
+		response.EstimatedSeconds = max(response.EstimatedSeconds, batch.EstimatedSeconds)
+	}
+
+	switch {
+	case succeeded == len(batches):
+		response.HTTPStatus = batches[0].HTTPStatus
+	case succeeded > 0:
+		response.HTTPStatus = http.StatusMultiStatus
+	}
+
+	if len(batches) == 1 {
+		response.Detail = batches[0].Detail
+	} else {
+		response.Detail = fmt.Sprintf("%d of %d purge requests accepted", succeeded, len(batches))
+	}
+
+	return response
+}
+
+// ParseCPCodes converts the given paths into numeric CP codes
+func ParseCPCodes(paths []string) (cpCodes []int, err error) {
+	for _, path := range paths {
+		cpCode, err := strconv.Atoi(path)
+		if err != nil || cpCode <= 0 {
+			return nil, fmt.Errorf("'%s' is not a valid CP code", path)
+		}
+		cpCodes = append(cpCodes, cpCode)
+	}
+
+	return cpCodes, nil
+}
+
+func is2xx(status int) bool {
+	return status >= 200 && status < 300
+}
@@ -0,0 +1,54 @@
+package purge
+
+import (
+	"akapurgo/api/v1alpha1"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// debugPragma asks the Akamai edge for the cache status, the cache key and the cacheability of the objects
+const debugPragma = "akamai-x-cache-on, akamai-x-get-cache-key, akamai-x-check-cacheable"
+
+// requestHeaders returns the headers of the post-purge requests: the Akamai debug Pragma,
+// then the configured headers, which may replace it
+func requestHeaders(configured map[string]string) map[string]string {
+	headers := map[string]string{"Pragma": debugPragma}
+	for key, value := range configured {
+		headers[http.CanonicalHeaderKey(key)] = value
+	}
+
+	return headers
+}
+
+// verify sets the cache status, cache key and age of the response of a post-purge request, and the verdict
+// drawn from them. Hits are fresh when the object was cached after the purge started
+func verify(result *v1alpha1.WarmedURL, response *http.Response, purgedAt time.Time) {
+	if fields := strings.Fields(response.Header.Get("X-Cache")); len(fields) > 0 {
+		result.Cache = fields[0]
+	}
+	result.CacheKey = response.Header.Get("X-Cache-Key")
+	if age, err := strconv.Atoi(response.Header.Get("Age")); err == nil {
+		result.Age = &age
+	}
+
+	switch {
+	case strings.EqualFold(response.Header.Get("X-Check-Cacheable"), "NO"):
+		result.Verdict = v1alpha1.VerdictNotCacheable
+	case result.Cache == "TCP_MISS" || result.Cache == "TCP_REFRESH_MISS":
+		result.Verdict = v1alpha1.VerdictFetched
+	case result.Cache == "TCP_REFRESH_HIT":
+		result.Verdict = v1alpha1.VerdictRevalidated
+	case result.Cache == "TCP_REFRESH_FAIL_HIT":
+		// The origin could not be reached, and the invalidated object was served
+		result.Verdict = v1alpha1.VerdictStaleHit
+	case strings.HasSuffix(result.Cache, "_HIT") && result.Age != nil:
+		result.Verdict = v1alpha1.VerdictFreshHit
+		if time.Duration(*result.Age)*time.Second > time.Since(purgedAt) {
+			result.Verdict = v1alpha1.VerdictStaleHit
+		}
+	default:
+		result.Verdict = v1alpha1.VerdictUnknown
+	}
+}
@@ -0,0 +1,175 @@
+package purge
+
+import (
+	"akapurgo/api/v1alpha1"
+	"cmp"
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// slowestURLs is the number of slowest URLs reported in the warming summary
+	slowestURLs = 10
+
+	// maxReportedErrors is the number of errors reported in the warming summary
+	maxReportedErrors = 50
+)
+
+// forEachPath calls fn for the paths from a pool of workers, until every path is done or the context is done.
+// It returns the number of paths fn was called for
+func forEachPath(parent context.Context, paths []string, concurrency int, fn func(path string)) (started int) {
+	pathsChan := make(chan string)
+
+	var wg sync.WaitGroup
+	for range max(min(concurrency, len(paths)), 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range pathsChan {
+				fn(path)
+			}
+		}()
+	}
+
+feed:
+	for _, path := range paths {
+		select {
+		case pathsChan <- path:
+			started++
+		case <-parent.Done():
+			break feed
+		}
+	}
+	close(pathsChan)
+	wg.Wait()
+
+	return started
+}
+
+// get sends a GET request with the Akamai debug Pragma and the post-purge headers to the path, limited to
+// the request timeout, and discards the body of the response
+func get(ctx v1alpha1.Context, parent context.Context, path string) (response *http.Response, err error) {
+	config := ctx.Config().PostPurgeRequest
+
+	requestCtx, cancel := context.WithTimeout(parent, config.RequestTimeout)
+	defer cancel()
+
+	getRequest, err := http.NewRequestWithContext(requestCtx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range requestHeaders(config.Headers) {
+		getRequest.Header.Set(key, value)
+	}
+
+	response, err = httpClient.Do(getRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	// Read and discard the body to complete the request properly
+	_, err = io.Copy(io.Discard, response.Body)
+	return response, err
+}
+
+// warmedURL is the result of a post-purge request, along with the time it took
+type warmedURL struct {
+	v1alpha1.WarmedURL
+	elapsed time.Duration
+}
+
+// executePurgeRequest sends a GET request to every purged path from a pool of workers, calling done after each
+// of them, and verifies whether the edge served objects cached after the purge started at purgedAt.
+// The requests not started before the deadline are skipped
+func executePurgeRequest(ctx v1alpha1.Context, paths []string, purgedAt time.Time,
+	done func(done int)) (summary v1alpha1.WarmingSummary) {
+	config := ctx.Config().PostPurgeRequest
+	start := time.Now()
+
+	deadline, cancel := context.WithTimeout(context.Background(), config.Deadline)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var results []warmedURL
+	done(0)
+	started := forEachPath(deadline, paths, config.Concurrency, func(path string) {
+		requestStart := time.Now()
+		response, err := get(ctx, deadline, path)
+
+		result := warmedURL{elapsed: time.Since(requestStart)}
+		result.URL = path
+		result.Duration = result.elapsed.Round(time.Millisecond).String()
+		if response != nil {
+			result.Status = response.StatusCode
+		}
+		if err != nil {
+			result.Error = err.Error()
+			ctx.Logger.Errorf("Failed to send GET request to %s: %v\n", path, err)
+		} else {
+			verify(&result.WarmedURL, response, purgedAt)
+			ctx.Logger.Infof("GET request to %s returned status code %d, cache %s, verdict %s\n", path,
+				response.StatusCode, result.Cache, result.Verdict)
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		results = append(results, result)
+		done(len(results))
+	})
+
+	summary = summarizeWarming(results)
+	summary.Skipped = len(paths) - started
+	summary.Duration = time.Since(start).Round(time.Millisecond).String()
+
+	ctx.Logger.Infof("purge-warming,requested=%d,failed=%d,skipped=%d,stale=%d,duration=%s",
+		summary.Requested, summary.Failed, summary.Skipped, summary.Verdicts[v1alpha1.VerdictStaleHit], summary.Duration)
+
+	return summary
+}
+
+// summarizeWarming counts the results by status code and verdict, and keeps the slowest URLs, the first errors,
+// and every result sorted by URL
+func summarizeWarming(results []warmedURL) (summary v1alpha1.WarmingSummary) {
+	summary.Requested = len(results)
+	summary.Statuses = map[int]int{}
+	summary.Slowest = []v1alpha1.WarmedURL{}
+	summary.Errors = []v1alpha1.WarmedURL{}
+	summary.Verdicts = map[string]int{}
+	summary.URLs = []v1alpha1.WarmedURL{}
+
+	var succeeded []warmedURL
+	for _, result := range results {
+		summary.URLs = append(summary.URLs, result.WarmedURL)
+
+		if result.Error != "" {
+			summary.Failed++
+			if len(summary.Errors) < maxReportedErrors {
+				summary.Errors = append(summary.Errors, result.WarmedURL)
+			}
+			continue
+		}
+
+		summary.Statuses[result.Status]++
+		summary.Verdicts[result.Verdict]++
+		succeeded = append(succeeded, result)
+	}
+
+	slices.SortFunc(succeeded, func(a, b warmedURL) int {
+		return cmp.Compare(b.elapsed, a.elapsed)
+	})
+	for _, result := range succeeded[:min(slowestURLs, len(succeeded))] {
+		summary.Slowest = append(summary.Slowest, result.WarmedURL)
+	}
+
+	slices.SortFunc(summary.URLs, func(a, b v1alpha1.WarmedURL) int {
+		return strings.Compare(a.URL, b.URL)
+	})
+
+	return summary
+}
@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"akapurgo/api/v1alpha1"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// ModeQueue waits for tokens up to the queue timeout
+	ModeQueue = "queue"
+
+	// ModeReject rejects the requests as soon as there are not enough tokens
+	ModeReject = "reject"
+)
+
+var (
+	ErrRateLimited = errors.New("rate limit exceeded")
+)
+
+// Limiter is a set of token buckets, one per purge type, shared by all the callers of the purge API.
+// Tokens are consumed per purged object, the same way Akamai accounts them in its quotas
+type Limiter struct {
+	enabled      bool
+	mode         string
+	queueTimeout time.Duration
+	buckets      map[string]*rate.Limiter
+}
+
+// RateLimitError is returned when a purge can not be done without exceeding the rate limit
+type RateLimitError struct {
+	PurgeType  string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s for %s purges, retry after %s", ErrRateLimited, e.PurgeType, e.RetryAfter.Round(time.Second))
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// NewLimiter creates the buckets from the configuration. Buckets without rate are unlimited
+func NewLimiter(ctx v1alpha1.Context) *Limiter {
+	config := ctx.Config().RateLimit
+
+	return &Limiter{
+		enabled:      config.Enabled,
+		mode:         config.Mode,
+		queueTimeout: config.QueueTimeout,
+		buckets: map[string]*rate.Limiter{
+			"urls":       newBucket(config.URLs),
+			"cache-tags": newBucket(config.CacheTags),
+			"cpcodes":    newBucket(config.CPCodes),
+		},
+	}
+}
+
+// newBucket creates a token bucket from its configuration
+func newBucket(config v1alpha1.RateLimitBucket) *rate.Limiter {
+	if config.ObjectsPerSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+
+	return rate.NewLimiter(rate.Limit(config.ObjectsPerSecond), max(config.Burst, 1))
+}
+
+// Wait takes the tokens needed to purge the given number of objects of a purge type.
+// Depending on the mode, it waits until the tokens are available or fails right away with a RateLimitError.
+// Requests bigger than the bucket take the whole bucket, as they could never fit in it
+func (l *Limiter) Wait(purgeType string, objects int) error {
+	if l == nil || !l.enabled {
+		return nil
+	}
+
+	bucket, ok := l.buckets[purgeType]
+	if !ok {
+		return nil
+	}
+
+	tokens := objects
+	if bucket.Limit() != rate.Inf {
+		tokens = min(objects, bucket.Burst())
+	}
+
+	reservation := bucket.ReserveN(time.Now(), tokens)
+	if !reservation.OK() {
+		return &RateLimitError{PurgeType: purgeType}
+	}
+
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+
+	if l.mode == ModeReject || delay > l.queueTimeout {
+		reservation.Cancel()
+		return &RateLimitError{PurgeType: purgeType, RetryAfter: delay}
+	}
+
+	time.Sleep(delay)
+	return nil
+}
@@ -0,0 +1,141 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// maxSecretSize is the maximum size in bytes read for a secret
+	maxSecretSize = 1 << 20
+)
+
+// FileProvider reads the secrets from files, such as mounted Kubernetes secrets: file:///var/run/secrets/token.
+// Surrounding whitespace is removed
+type FileProvider struct{}
+
+func (p *FileProvider) Get(ref string) (string, error) {
+	parsedURL, err := url.Parse(ref)
+	if err != nil || parsedURL.Path == "" {
+		return "", fmt.Errorf("invalid file reference '%s'", ref)
+	}
+
+	file, err := os.Open(parsedURL.Path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	secret, err := io.ReadAll(io.LimitReader(file, maxSecretSize))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(secret)), nil
+}
+
+// ExecProvider runs a command and reads the secret from its output: exec:/usr/bin/vault kv get -field=token secret/akamai.
+// The command is not run through a shell, and surrounding whitespace is removed from the output
+type ExecProvider struct {
+	Timeout time.Duration
+}
+
+func (p *ExecProvider) Get(ref string) (string, error) {
+	args := strings.Fields(strings.TrimPrefix(ref, "exec:"))
+	if len(args) == 0 {
+		return "", fmt.Errorf("invalid exec reference '%s'", ref)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	var stderr strings.Builder
+	command := exec.CommandContext(ctx, args[0], args[1:]...)
+	command.Stderr = &stderr
+
+	output, err := command.Output()
+	if err != nil {
+		return "", fmt.Errorf("command '%s' failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// HTTPProvider gets the secrets from an HTTP endpoint, such as Vault. The body of the response is the secret,
+// unless the fragment of the reference selects a field of a JSON body with a dotted path:
+// https://vault.example.com/v1/secret/data/akamai#data.data.client_secret
+type HTTPProvider struct {
+	Headers map[string]string
+	Timeout time.Duration
+}
+
+func (p *HTTPProvider) Get(ref string) (string, error) {
+	parsedURL, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid HTTP reference '%s'", ref)
+	}
+	field := parsedURL.Fragment
+	parsedURL.Fragment = ""
+
+	request, err := http.NewRequest("GET", parsedURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	for key, value := range p.Headers {
+		request.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: p.Timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned status code %d", parsedURL.Redacted(), response.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxSecretSize))
+	if err != nil {
+		return "", err
+	}
+
+	if field == "" {
+		return strings.TrimSpace(string(body)), nil
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(body, &document); err != nil {
+		return "", fmt.Errorf("failed to decode JSON from %s: %v", parsedURL.Redacted(), err)
+	}
+
+	return jsonField(document, field)
+}
+
+// jsonField returns the string at the dotted path of a JSON document
+func jsonField(document interface{}, path string) (string, error) {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := document.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("field '%s' not found", path)
+		}
+		document = object[key]
+	}
+
+	value, ok := document.(string)
+	if !ok {
+		return "", errors.New("field '" + path + "' is not a string")
+	}
+
+	return value, nil
+}
@@ -0,0 +1,57 @@
+package secrets
+
+import (
+	"akapurgo/api/v1alpha1"
+	"fmt"
+	"strings"
+)
+
+// Provider gets the secrets referenced with the schemes it is registered for
+type Provider interface {
+	// Get returns the secret the reference points to
+	Get(ref string) (string, error)
+}
+
+// Resolver resolves the secret references found in the configuration, such as file:///var/run/secrets/token,
+// with the provider registered for their scheme. Values that are not references are returned as they are
+type Resolver struct {
+	providers map[string]Provider
+}
+
+// NewResolver creates a resolver with the built-in providers: file, exec, http and https
+func NewResolver(config v1alpha1.SecretsConfig) *Resolver {
+	httpProvider := &HTTPProvider{Headers: config.HTTP.Headers, Timeout: config.Timeout}
+
+	resolver := &Resolver{providers: map[string]Provider{}}
+	resolver.Register("file", &FileProvider{})
+	resolver.Register("exec", &ExecProvider{Timeout: config.Timeout})
+	resolver.Register("http", httpProvider)
+	resolver.Register("https", httpProvider)
+
+	return resolver
+}
+
+// Register sets the provider of the references with the given scheme, replacing the previous one
+func (r *Resolver) Register(scheme string, provider Provider) {
+	r.providers[scheme] = provider
+}
+
+// Resolve returns the secret referenced by the value, or the value itself when it is not a reference
+func (r *Resolver) Resolve(value string) (string, error) {
+	scheme, _, found := strings.Cut(value, ":")
+	if !found {
+		return value, nil
+	}
+
+	provider, ok := r.providers[scheme]
+	if !ok {
+		return value, nil
+	}
+
+	secret, err := provider.Get(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secret from %s provider: %v", scheme, err)
+	}
+
+	return secret, nil
+}
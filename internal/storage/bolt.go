@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"akapurgo/api/v1alpha1"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	purgesBucket = []byte("purges")
+)
+
+// BoltStore keeps the purge history in an embedded bbolt database.
+// Records are stored as JSON under their ID, which keeps them sorted by creation time
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens, or creates, the database at the given path
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(purgesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bucket: %v", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Save creates or replaces the given record
+func (s *BoltStore) Save(record v1alpha1.PurgeRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %v", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(purgesBucket).Put([]byte(record.ID), recordBytes)
+	})
+}
+
+// Get returns the record with the given ID, or ErrNotFound
+func (s *BoltStore) Get(id string) (record v1alpha1.PurgeRecord, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		recordBytes := tx.Bucket(purgesBucket).Get([]byte(id))
+		if recordBytes == nil {
+			return ErrNotFound
+		}
+
+		return json.Unmarshal(recordBytes, &record)
+	})
+
+	return record, err
+}
+
+// Update applies the given function to the record with the given ID and saves it in the same transaction
+func (s *BoltStore) Update(id string, update func(record *v1alpha1.PurgeRecord) error) (record v1alpha1.PurgeRecord, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(purgesBucket)
+
+		recordBytes := bucket.Get([]byte(id))
+		if recordBytes == nil {
+			return ErrNotFound
+		}
+
+		if err := json.Unmarshal(recordBytes, &record); err != nil {
+			return fmt.Errorf("failed to decode record %s: %v", id, err)
+		}
+
+		if err := update(&record); err != nil {
+			return err
+		}
+
+		recordBytes, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode record: %v", err)
+		}
+
+		return bucket.Put([]byte(id), recordBytes)
+	})
+
+	return record, err
+}
+
+// List returns the records matching the filter, newest first, along with the total number of matches
+func (s *BoltStore) List(filter v1alpha1.PurgeFilter) (records []v1alpha1.PurgeRecord, total int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(purgesBucket).Cursor()
+
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			var record v1alpha1.PurgeRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return fmt.Errorf("failed to decode record %s: %v", key, err)
+			}
+
+			// Records are sorted by creation time, so nothing older can match
+			if !filter.From.IsZero() && record.CreatedAt.Before(filter.From) {
+				break
+			}
+
+			if !Matches(record, filter) {
+				continue
+			}
+
+			if total >= filter.Offset && len(records) < filter.Limit {
+				records = append(records, record)
+			}
+			total++
+		}
+
+		return nil
+	})
+
+	return records, total, err
+}
+
+// Close closes the database
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"akapurgo/api/v1alpha1"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLimit is the number of records returned when no limit is requested
+	DefaultLimit = 50
+
+	// MaxLimit is the maximum number of records returned at once
+	MaxLimit = 500
+)
+
+var (
+	ErrNotFound = errors.New("record not found")
+)
+
+// Store keeps the history of the purges
+type Store interface {
+	// Save creates or replaces the given record
+	Save(record v1alpha1.PurgeRecord) error
+
+	// Get returns the record with the given ID, or ErrNotFound
+	Get(id string) (v1alpha1.PurgeRecord, error)
+
+	// Update applies the given function to the record with the given ID and saves it, atomically.
+	// Nothing is saved when the function fails. ErrNotFound is returned when the record does not exist
+	Update(id string, update func(record *v1alpha1.PurgeRecord) error) (v1alpha1.PurgeRecord, error)
+
+	// List returns the records matching the filter, newest first, along with the total number of matches
+	List(filter v1alpha1.PurgeFilter) (records []v1alpha1.PurgeRecord, total int, err error)
+
+	// Close releases the resources of the store
+	Close() error
+}
+
+// NewStore creates the store configured for the purge history. No store is returned when the history is disabled
+func NewStore(ctx v1alpha1.Context) (Store, error) {
+	if !ctx.Config().History.Enabled {
+		return nil, nil
+	}
+
+	store, err := NewBoltStore(ctx.Config().History.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// NewID returns a new record ID. IDs are sorted by creation time, so records can be listed in order
+func NewID(now time.Time) (string, error) {
+	id := make([]byte, 12)
+	binary.BigEndian.PutUint64(id, uint64(now.UnixNano()))
+	if _, err := rand.Read(id[8:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// Matches returns whether the record matches all the conditions of the filter
+func Matches(record v1alpha1.PurgeRecord, filter v1alpha1.PurgeFilter) bool {
+	if filter.User != "" && record.User != filter.User {
+		return false
+	}
+
+	if filter.Status != "" && record.Status != filter.Status {
+		return false
+	}
+
+	if !filter.From.IsZero() && record.CreatedAt.Before(filter.From) {
+		return false
+	}
+
+	if !filter.To.IsZero() && record.CreatedAt.After(filter.To) {
+		return false
+	}
+
+	if filter.Path != "" && !slices.ContainsFunc(record.Paths, func(path string) bool {
+		return strings.Contains(path, filter.Path)
+	}) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,132 @@
+package validation
+
+import (
+	"akapurgo/api/v1alpha1"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// CacheTagMaxLength is the maximum length Akamai allows for a cache tag
+	CacheTagMaxLength = 128
+
+	// CacheTagPattern matches the characters Akamai allows inside a cache tag
+	CacheTagPattern = "^[A-Za-z0-9!#$%&'*+\\-.^_`|~]+$"
+)
+
+var (
+	CacheTagPatternCompiled = regexp.MustCompile(CacheTagPattern)
+
+	PurgeTypes   = []string{"urls", "cache-tags", "cpcodes"}
+	ActionTypes  = []string{"invalidate", "delete"}
+	Environments = []string{"production", "staging"}
+)
+
+// ValidatePurgeRequest checks every field of the given purge request before sending anything to Akamai.
+// One error is returned per wrong field, and per wrong entry for the paths
+func ValidatePurgeRequest(req v1alpha1.PurgeRequest) (errs []v1alpha1.ValidationError) {
+
+	if !slices.Contains(PurgeTypes, req.PurgeType) {
+		errs = append(errs, v1alpha1.ValidationError{
+			Field:   "purgeType",
+			Value:   req.PurgeType,
+			Message: fmt.Sprintf("must be one of: %s", strings.Join(PurgeTypes, ", ")),
+		})
+	}
+
+	if !slices.Contains(ActionTypes, req.ActionType) {
+		errs = append(errs, v1alpha1.ValidationError{
+			Field:   "actionType",
+			Value:   req.ActionType,
+			Message: fmt.Sprintf("must be one of: %s", strings.Join(ActionTypes, ", ")),
+		})
+	}
+
+	if !slices.Contains(Environments, req.Environment) {
+		errs = append(errs, v1alpha1.ValidationError{
+			Field:   "environment",
+			Value:   req.Environment,
+			Message: fmt.Sprintf("must be one of: %s", strings.Join(Environments, ", ")),
+		})
+	}
+
+	if len(req.Paths) == 0 {
+		errs = append(errs, v1alpha1.ValidationError{
+			Field:   "paths",
+			Message: "must contain at least one entry",
+		})
+		return errs
+	}
+
+	// Select the validator for the entries of the list depending on the purge type.
+	// Paths can not be checked when the purge type is unknown
+	var validatePath func(path string) error
+	switch req.PurgeType {
+	case "urls":
+		validatePath = validateURL
+	case "cache-tags":
+		validatePath = validateCacheTag
+	case "cpcodes":
+		validatePath = validateCPCode
+	default:
+		return errs
+	}
+
+	for index, path := range req.Paths {
+		if err := validatePath(path); err != nil {
+			errs = append(errs, v1alpha1.ValidationError{
+				Field:   "paths",
+				Index:   &index,
+				Value:   path,
+				Message: err.Error(),
+			})
+		}
+	}
+
+	return errs
+}
+
+// validateURL checks the given path is an absolute http(s) URL
+func validateURL(path string) error {
+	parsedURL, err := url.Parse(path)
+	if err != nil {
+		return fmt.Errorf("must be a valid URL")
+	}
+
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return fmt.Errorf("must be an absolute URL with http or https scheme")
+	}
+
+	if parsedURL.Host == "" {
+		return fmt.Errorf("must contain a hostname")
+	}
+
+	return nil
+}
+
+// validateCacheTag checks the given tag follows the Akamai rules for cache tags
+func validateCacheTag(tag string) error {
+	if len(tag) == 0 || len(tag) > CacheTagMaxLength {
+		return fmt.Errorf("must be between 1 and %d characters long", CacheTagMaxLength)
+	}
+
+	if !CacheTagPatternCompiled.MatchString(tag) {
+		return fmt.Errorf("must only contain alphanumeric characters and any of !#$%%&'*+-.^_`|~")
+	}
+
+	return nil
+}
+
+// validateCPCode checks the given CP code is a positive number
+func validateCPCode(cpCode string) error {
+	number, err := strconv.Atoi(cpCode)
+	if err != nil || number <= 0 {
+		return fmt.Errorf("must be a positive number")
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+// Approve or reject the purges pending approval. Approved purges run in background, the page is reloaded
+// once the review is stored
+document.querySelectorAll('button.review').forEach(button => {
+    button.addEventListener('click', async function() {
+        const messageElement = document.getElementById('message');
+        const decision = button.dataset.decision;
+
+        const comment = window.prompt(`Comment for the ${decision === 'approve' ? 'approval' : 'rejection'} (optional):`);
+        if (comment === null) {
+            return;
+        }
+
+        try {
+            const response = await fetch(`/api/v1/purges/${button.dataset.id}/${decision}`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json'
+                },
+                body: JSON.stringify({ comment })
+            });
+
+            const data = await response.json();
+            if (!response.ok) {
+                messageElement.textContent = `Error: ${data.error || 'Failed to review purge.'}`;
+                messageElement.className = 'message error';
+                return;
+            }
+
+            window.location.reload();
+        } catch (error) {
+            messageElement.textContent = 'An unexpected error occurred. Please try again.';
+            messageElement.className = 'message error';
+        }
+    });
+});
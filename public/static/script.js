@@ -8,16 +8,20 @@ document.getElementById('purge-form').addEventListener('submit', async function(
     const actionType = document.getElementById('action-type').value;
     const environment = document.getElementById('environment').value;
     const postPurgeRequest = document.getElementById('post-request').checked;
-    const paths = document.getElementById('paths').value.trim().split('\n').filter(Boolean);
+    const dryRun = document.getElementById('dry-run').checked;
+    const accountSelect = document.getElementById('account');
+    const account = accountSelect ? accountSelect.value : '';
+    const paths = document.getElementById('paths').value.split('\n').map(path => path.trim()).filter(Boolean);
 
     if (paths.length === 0) {
-        messageElement.textContent = 'Please enter at least one path or tag.';
+        messageElement.textContent = 'Please enter at least one path, tag or CP code.';
         messageElement.className = 'message error';
         return;
     }
 
     try {
-        const response = await fetch('/api/v1/purge', {
+        // Purges run in background so long lists do not time out, the job is polled until it finishes
+        const response = await fetch('/api/v1/purge?async=true', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json'
@@ -27,17 +31,35 @@ document.getElementById('purge-form').addEventListener('submit', async function(
                 actionType,
                 environment,
                 postPurgeRequest,
+                dryRun,
+                account,
                 paths
             })
         });
 
-        if (response.ok) {
-            messageElement.textContent = 'Cache purged successfully.';
-            messageElement.className = 'message success';
+        if (!response.ok) {
+            showError(messageElement, await response.json());
+            return;
+        }
+
+        const data = await response.json();
+        if (data.dryRun) {
+            showPlan(messageElement, data);
+            return;
+        }
+
+        // Purges needing approval are stored as pending, they run once a second user approves them
+        if (data.status === 'pending') {
+            messageElement.textContent = `The purge is waiting for approval (${data.detail}).\nIt will run once approved by a second user in the Approvals page.`;
+            messageElement.className = 'message info';
+            return;
+        }
+
+        const job = await waitForJob(messageElement, data.id);
+        if (job.stage === 'done') {
+            showSuccess(messageElement, job.response);
         } else {
-            const errorData = await response.json();
-            messageElement.textContent = `Error: ${errorData.message || 'Failed to purge cache.'}`;
-            messageElement.className = 'message error';
+            showError(messageElement, { ...job.response, error: job.error });
         }
     } catch (error) {
         messageElement.textContent = 'An unexpected error occurred. Please try again.';
@@ -45,6 +67,109 @@ document.getElementById('purge-form').addEventListener('submit', async function(
     }
 });
 
+const stageMessages = {
+    'queued': 'Purge queued...',
+    'submitted': 'Sending purge to Akamai...',
+    'waiting': 'Waiting for the purge to propagate...',
+    'polling': 'Waiting for the edge to serve the new objects...',
+    'warming': 'Warming purged URLs...'
+};
+
+// waitForJob polls the status of a purge job until it is done or failed
+async function waitForJob(messageElement, id) {
+    for (;;) {
+        const response = await fetch(`/api/v1/purge/${id}`);
+        const job = await response.json();
+        if (!response.ok || job.stage === 'done' || job.stage === 'failed') {
+            return job;
+        }
+
+        const progress = job.progress && job.progress.total ? ` (${job.progress.done}/${job.progress.total})` : '';
+        messageElement.textContent = `${stageMessages[job.stage] || job.stage}${progress}`;
+        messageElement.className = 'message';
+        await new Promise(resolve => setTimeout(resolve, 1000));
+    }
+}
+
+// showPlan shows the Akamai calls and warm-up requests a dry run would send
+function showPlan(messageElement, plan) {
+    const lines = [`Dry run on the '${plan.account}' account, nothing was purged.`];
+    if (plan.approvalRequired) {
+        lines.push(`The purge would wait for approval (rule '${plan.approvalRequired}').`);
+    }
+    plan.calls.forEach((call, index) => {
+        lines.push(`Call ${index + 1}/${plan.calls.length}: ${call.method} ${call.url} (${call.objects} objects)`);
+    });
+    if (plan.postPurge) {
+        lines.push(`Then ${plan.postPurge.requests.length} warm-up GET requests after ${plan.postPurge.delay}` +
+            (plan.postPurge.pollPropagation ? ', once the edge serves the new objects.' : '.'));
+    }
+    messageElement.textContent = lines.join('\n');
+    messageElement.className = 'message info';
+}
+
+function showSuccess(messageElement, data) {
+    const purgeIds = (data.batches || []).map(batch => batch.purgeId).filter(Boolean);
+    messageElement.textContent = 'Cache purged successfully.';
+    if (purgeIds.length > 0) {
+        messageElement.textContent += `\nPurge IDs: ${purgeIds.join(', ')}`;
+    }
+    if (data.estimatedSeconds) {
+        messageElement.textContent += `\nEstimated seconds: ${data.estimatedSeconds}`;
+    }
+    if (data.warming) {
+        const warming = data.warming;
+        const statuses = Object.entries(warming.statuses).map(([status, count]) => `${count} × ${status}`).join(', ');
+        messageElement.textContent += `\nWarmed ${warming.requested} URLs in ${warming.duration}${statuses ? ` (${statuses})` : ''}`;
+        if (warming.failed > 0 || warming.skipped > 0) {
+            messageElement.textContent += `\nWarming failed for ${warming.failed} URLs, ${warming.skipped} skipped at the deadline`;
+        }
+        const verdicts = Object.entries(warming.verdicts || {}).map(([verdict, count]) => `${count} ${verdict}`).join(', ');
+        if (verdicts) {
+            messageElement.textContent += `\nVerdicts: ${verdicts}`;
+        }
+        const stale = (warming.urls || []).filter(url => url.verdict === 'still-stale-hit').map(url => url.url);
+        if (stale.length > 0) {
+            messageElement.textContent += `\nStill stale: ${stale.join(', ')}`;
+        }
+    }
+    if (data.propagation) {
+        const propagation = data.propagation;
+        messageElement.textContent += `\nPropagation: ${propagation.changed} URLs serve new objects after ${propagation.duration}`;
+        if (propagation.unchanged.length > 0) {
+            messageElement.textContent += `\nStill serving the old objects: ${propagation.unchanged.join(', ')}`;
+        }
+        if (propagation.unverified.length > 0) {
+            messageElement.textContent += `\nNot verified (no ETag, Last-Modified nor X-Cache): ${propagation.unverified.length} URLs`;
+        }
+    }
+    messageElement.className = 'message success';
+}
+
+function showError(messageElement, errorData) {
+    // Errors coming from Akamai are reported per batch with their title and support ID
+    (errorData.batches || []).filter(batch => batch.httpStatus >= 300).forEach(batch => {
+        errorData.errors = errorData.errors || [];
+        errorData.errors.push({
+            field: batch.title || `HTTP ${batch.httpStatus}`,
+            message: `${batch.detail}${batch.supportId ? ` (support ID: ${batch.supportId})` : ''}`
+        });
+    });
+    errorData.error = errorData.error || errorData.detail;
+    if (errorData.reason) {
+        errorData.error += `\n${errorData.reason}`;
+    }
+    const details = (errorData.errors || []).map(err => {
+        const field = err.index !== undefined ? `${err.field}[${err.index}]` : err.field;
+        return `${field}: ${err.message}`;
+    });
+    messageElement.textContent = `Error: ${errorData.error || 'Failed to purge cache.'}`;
+    if (details.length > 0) {
+        messageElement.textContent += `\n${details.join('\n')}`;
+    }
+    messageElement.className = 'message error';
+}
+
 document.addEventListener("DOMContentLoaded", () => {
     const purgeTypeSelect = document.getElementById("purge-type");
     const pathsTextarea = document.getElementById("paths");
@@ -52,7 +177,8 @@ document.addEventListener("DOMContentLoaded", () => {
     // Definir los placeholders para cada opción
     const placeholders = {
         "urls": "https://domain.com/example/path1\nhttps://domain.com/example/path2",
-        "cache-tags": "tag1\ntag2\ntag3"
+        "cache-tags": "tag1\ntag2\ntag3",
+        "cpcodes": "123456\n654321"
     };
 
     // Actualizar el placeholder cuando cambie el tipo de purga